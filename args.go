@@ -0,0 +1,191 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// restArg sorts `arg:"rest"` after every numbered positional argument.
+const restArg = int(^uint(0) >> 1)
+
+// position is the index of a positional argument field, restArg for rest.
+func (f *field) position() int {
+	if f.tag.Get("arg") == "rest" {
+		return restArg
+	}
+	n, _ := strconv.Atoi(f.tag.Get("arg"))
+	return n
+}
+
+// argSyntax is how the usage line shows a positional argument: <SRC> when
+// required, [SRC] when optional, with ... for rest.
+func (f *field) argSyntax() string {
+	name := strings.ToUpper(f.name)
+	if f.position() == restArg {
+		name += "..."
+	}
+	if f.required() {
+		return "<" + name + ">"
+	}
+	return "[" + name + "]"
+}
+
+// checkArgTag reports an `arg` tag that is neither a position nor rest on a
+// []string.
+func checkArgTag(sf reflect.StructField, path string) error {
+	arg, ok := sf.Tag.Lookup("arg")
+	switch {
+	case !ok:
+		return nil
+	case arg == "rest":
+		if sf.Type.Kind() != reflect.Slice {
+			return fmt.Errorf(`arg:"rest" needs a []string @ %s`, path)
+		}
+		return nil
+	}
+	if n, err := strconv.Atoi(arg); err != nil || n < 0 {
+		return fmt.Errorf("invalid arg tag %q @ %s", arg, path)
+	}
+	return nil
+}
+
+// positionalArgs are the args left after flags when cmd is the command
+// being run, else none.
+func (c *Config) positionalArgs(cmd *cobra.Command) []string {
+	switch {
+	case c.goFlagSet != nil:
+		return c.goFlagSet.Args()
+	case c.flagSet != nil:
+		return c.flagSet.Args()
+	case c.root().target == cmd:
+		return cmd.Flags().Args()
+	}
+	return nil
+}
+
+// addArg registers a field tagged `arg:"N"` or `arg:"rest"` as a positional
+// argument instead of a flag.
+func (c *Config) addArg(f *field) {
+	c.positional = append(c.positional, f)
+	sort.SliceStable(c.positional, func(i, j int) bool {
+		return c.positional[i].position() < c.positional[j].position()
+	})
+}
+
+// argUsage appends the positional arguments to the command's usage line,
+// unless Use already describes them.
+func (c *Config) argUsage() {
+	if len(c.positional) == 0 || strings.Contains(c.Cmd.Use, " ") {
+		return
+	}
+	parts := []string{c.Cmd.Use}
+	for _, f := range c.positional {
+		parts = append(parts, f.argSyntax())
+	}
+	c.Cmd.Use = strings.Join(parts, " ")
+}
+
+// bindArgs sets the positional argument fields from args, falling back to
+// their default tags.
+func (c *Config) bindArgs(args []string) error {
+	c.argSet = map[string]bool{}
+	if len(c.positional) == 0 {
+		return nil
+	}
+	var errs Errors
+	max := 0
+	for _, f := range c.positional {
+		var vals []string
+		n := f.position()
+		switch {
+		case n == restArg:
+			if max < len(args) {
+				vals = args[max:]
+				max = len(args)
+			}
+		case n < len(args):
+			vals = args[n : n+1]
+		}
+		if n != restArg && n >= max {
+			max = n + 1
+		}
+		if len(vals) > 0 {
+			c.argSet[f.Path()] = true
+		} else if def := f.defaultTag(); def != "" {
+			vals = strings.Split(def, ",")
+		} else {
+			continue
+		}
+		if err := setValue(f.value, vals); err != nil {
+			errs = append(errs, c.argError(f, err))
+		}
+	}
+	if len(args) > max {
+		return fmt.Errorf("accepts at most %d arg(s), received %d", max, len(args))
+	}
+	return errs.err()
+}
+
+// checkArgs reports missing required positional arguments and values outside
+// their enum, min or max.
+func (c *Config) checkArgs() Errors {
+	if c.flagSet == nil && c.root().target != c.Cmd {
+		return nil // a subcommand takes the args
+	}
+	var errs Errors
+	for _, f := range c.positional {
+		given := c.argSet[f.Path()] || f.defaultTag() != ""
+		if f.required() && !given {
+			errs = append(errs, c.argError(f, ErrRequired))
+			continue
+		}
+		if !given {
+			continue // an omitted optional argument keeps its zero value
+		}
+		for _, fe := range c.checkValue(f) {
+			errs = append(errs, c.argError(f, fe.Err))
+		}
+	}
+	return errs
+}
+
+// argError is a FieldError naming the positional argument rather than a flag.
+func (c *Config) argError(f *field, err error) *FieldError {
+	fe := c.fieldError(f, err)
+	fe.Flag, fe.Env, fe.Key = f.argSyntax(), "", ""
+	return fe
+}
+
+// setValue converts vals to the kind of v, as flags do.
+func setValue(v reflect.Value, vals []string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(vals[0])
+	case reflect.Bool:
+		b, err := strconv.ParseBool(vals[0])
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		i, err := strconv.ParseInt(vals[0], 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Float32, reflect.Float64:
+		fl, err := strconv.ParseFloat(vals[0], 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(fl)
+	case reflect.Slice:
+		v.Set(reflect.ValueOf(vals))
+	}
+	return nil
+}
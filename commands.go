@@ -0,0 +1,108 @@
+package config
+
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// AddCommand adds cmd as a subcommand with its own config struct. The fields
+// of cfg become local flags of cmd, env vars get the subcommand's name as a
+// sub-prefix (CONF_SERVE_PORT) and file keys live under a table of that name
+// (serve.port). When cmd runs, both the parent's and cmd's structs are loaded
+// and checked before its own PreRun, so both are ready in its Run.
+func (c *Config) AddCommand(cmd *cobra.Command, cfg interface{}) *Config {
+	sub := &Config{
+		Viper:  viper.New(),
+		Cmd:    cmd,
+		cfg:    cfg,
+		parent: c,
+	}
+	c.subs = append(c.subs, sub)
+	c.Cmd.AddCommand(cmd)
+	cmd.SetUsageFunc(sub.usage)
+	sub.hookPreRun()
+	if c.fields != nil {
+		sub.setup()
+	}
+	return sub
+}
+
+// root is the Config of the root command.
+func (c *Config) root() *Config {
+	for c.parent != nil {
+		c = c.parent
+	}
+	return c
+}
+
+// keyPrefix is the file table of a subcommand's keys, "" for the root.
+func (c *Config) keyPrefix() string {
+	if c.parent == nil {
+		return ""
+	}
+	return c.parent.keyPrefix() + strings.ToLower(c.Cmd.Name()) + "."
+}
+
+// active lists the subcommand configs on the path to the command being run.
+func (c *Config) active() []*Config {
+	var subs []*Config
+	for _, sub := range c.subs {
+		for cmd := c.root().target; cmd != nil; cmd = cmd.Parent() {
+			if cmd == sub.Cmd {
+				subs = append(subs, sub)
+				subs = append(subs, sub.active()...)
+				break
+			}
+		}
+	}
+	return subs
+}
+
+// all lists c and every subcommand config below it.
+func (c *Config) all() []*Config {
+	all := []*Config{c}
+	for _, sub := range c.subs {
+		all = append(all, sub.all()...)
+	}
+	return all
+}
+
+// load fills a subcommand's struct from the flags and env vars and from the
+// config file the root read.
+func (c *Config) load() error {
+	if errs := c.applyEnv(); len(errs) > 0 {
+		return errs
+	}
+	c.file = c.root().file
+	if c.file != nil {
+		if err := c.file.UnmarshalKey(strings.TrimSuffix(c.keyPrefix(), "."), c.cfg); err != nil {
+			return err
+		}
+		if err := c.applyKeys(); err != nil {
+			return err
+		}
+	}
+	if err := c.applyDeprecated(); err != nil {
+		return err
+	}
+	if err := c.getCfg(c.cfg); err != nil {
+		return err
+	}
+	return c.bindArgs(c.positionalArgs(c.Cmd))
+}
+
+// own lists the flag names of c's fields and those of its parents.
+func (c *Config) own() map[string]bool {
+	own := map[string]bool{}
+	for p := c; p != nil; p = p.parent {
+		for _, f := range p.fields {
+			own[f.flag] = true
+			for _, a := range f.aliases {
+				own[a.flag] = true
+			}
+		}
+	}
+	return own
+}
@@ -1,13 +1,19 @@
 package config
 
 import (
+	"context"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"reflect"
 	"strconv"
 	"strings"
+	"unicode"
 
+	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -20,91 +26,214 @@ type Config struct {
 	Cmd            *cobra.Command
 	parsed         bool
 	Args           []string
+	argsSet        bool
+	fields         []*field
+	file           *viper.Viper           // the config file layer on its own
+	origin         map[string]Source      // fields set before layering, by Go path
+	preset         map[string]interface{} // and their values at that point
+	strict         strictMode
+	configPaths    []string
+	logger         *slog.Logger
+	env            map[string]string // nil for the process environment
+	out            io.Writer
+	afs            afero.Fs
+	fromEnv        map[string]bool // flags set from an env var, by name
+	flagSet        *pflag.FlagSet  // set by BindFlagSet instead of cobra's flags
+	goFlagSet      *flag.FlagSet   // set by BindGoFlagSet, mirrors flagSet
+	parent         *Config         // set for subcommands, see AddCommand
+	subs           []*Config
+	target         *cobra.Command  // the command the args select
+	positional     []*field        // fields tagged `arg`, in order
+	argSet         map[string]bool // positional fields given on the command line
+	hooks          Hooks
+	naming         naming
 }
 
-/* New creates a config parser using a provided cfg struct.
-   name, desc are for the help window.
-   cfg can be decorated with the following tags:
-	 	- `required:"true"`: must be specified and non-zero.
-		   Most naturally used on strings, but can be used on numbers, but
-			 CANNOT be used if zero is a possible value. (Not supported for bools)
-		- `default:"val"`: if a value is not specified, replace with tag value.
-		  The same zero caviat as above applies here as well.
-		- `description:"this is the desc"`: description to use in help menu.
-*/
-func New(name string, desc string, cfg interface{}) *Config {
+// New creates a config parser using a provided cfg struct. name and desc are
+// for the help window. cfg can be decorated with the following tags:
+//
+//   - `required:"true"`: must be set by a flag, env var, the config file,
+//     a default or in the struct itself. An explicit zero (--port 0) counts,
+//     an empty list doesn't.
+//   - `default:"val"`: if a value is not specified, replace with tag value.
+//   - `description:"this is the desc"`: description to use in help menu.
+//   - `secret:"true"`: the value is redacted in help, docs, errors and
+//     --print-config, and giving it on the command line logs a warning.
+//   - `sources:"env,file"`: the only ways the field may be set; a flag or
+//     file key it doesn't accept is an error, an env var is ignored.
+//     `noflag:"true"` is short for `sources:"env,file"`.
+//   - `enum:"debug,info,error"`: the allowed values.
+//   - `min:"1"`, `max:"65535"`: bounds for numbers.
+//   - `requires:"TLSKey"`, `conflicts:"Addr"`, `group:"auth,oneof"`,
+//     `required_if:"Mode=tls"`: cross-field rules, see ConstraintError.
+//   - `alias:"OldName"` or `alias:"old-name"`: old Go field or flag names
+//     whose flag, env var and file key still set this field, with a
+//     deprecation warning.
+//   - `deprecated:"use --new-name; removed in v3"`: the warning for aliases,
+//     or, without an alias, marks the field itself deprecated.
+//   - `flag:"listen"`, `short:"l"`, `env:"LISTEN_ADDR"`, `key:"listen_address"`:
+//     names instead of the ones derived from the Go field name, relative to
+//     the parent struct. `env:"DATABASE_URL,noprefix"` is used verbatim.
+//   - `arg:"0"`, `arg:"rest"`: a positional argument instead of a flag.
+//   - `hidden:"true"`: left out of help and docs, but still set by its
+//     flag, env var and file key (unlike `flag:"false"`).
+//   - `advanced:"true"`: only listed by --help-all.
+//
+// The root struct and any nested struct may implement Defaulter and/or
+// Validator. Missing required fields are all reported together as Errors.
+// Fields whose flags or env vars would share a name (SubString and
+// Sub.String, or a field named Config) are reported by Execute as
+// CollisionErrors; NewTyped and BindFlagSet report them right away.
+func New(name string, desc string, cfg interface{}, opts ...Option) *Config {
 	return NewWithCommand(
 		&cobra.Command{
 			Use:  name,
 			Long: desc,
 			Run:  func(cmd *cobra.Command, args []string) {},
-		}, cfg)
+		}, cfg, opts...)
 }
 
 // NewWithCommand creates the config parser using an existing cobra command.
-func NewWithCommand(cmd *cobra.Command, cfg interface{}) *Config {
+func NewWithCommand(cmd *cobra.Command, cfg interface{}, opts ...Option) *Config {
 	// To avoid panics vvv
 	cmd.ResetFlags()
-	cmd.ResetCommands()
 
 	c := &Config{
 		Viper: viper.New(),
 		Cmd:   cmd,
 		cfg:   cfg,
 	}
-	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
-		return c.checkRequiredFlags(cmd.Flags())
+	for _, opt := range opts {
+		opt(c)
 	}
-	c.Cmd.PersistentFlags().String("config", "", "The configuration file")
-	c.Viper.BindPFlag("config", c.Cmd.PersistentFlags().Lookup("config"))
+	cmd.SetUsageFunc(c.usage)
+	c.hookPreRun()
+	c.addBuiltins()
 
 	return c
 }
 
+// check runs the required, constraint and Validator checks on the loaded config.
+func (c *Config) check() error {
+	errs := c.checkSources()
+	errs = append(errs, c.checkRequired()...)
+	errs = append(errs, c.checkArgs()...)
+	errs = append(errs, c.checkConstraints()...)
+	if err := errs.err(); err != nil {
+		return err
+	}
+	return c.validate()
+}
+
 // SetArgs sets args to use instead of the default os.Args (useful for testing).
-// This is used instead of cobraCommand.SetArgs()
+// This is used instead of cobraCommand.SetArgs(). An empty args means no args.
 func (c *Config) SetArgs(args []string) {
 	c.Args = args
+	c.argsSet = true
 }
 
 // do pre-execute parsing of config file.
 func (c *Config) parse() (interface{}, error) {
 	defer func() { c.parsed = true }()
+	if err := checkShape(c.cfg); err != nil {
+		return nil, err
+	}
+	if err := c.checkCollisions(); err != nil {
+		return nil, err
+	}
 	if c.parsed {
+		// Keep the args given to SetArgs since the last run.
+		args, argsSet := c.Args, c.argsSet
 		c.Reset()
+		c.Args, c.argsSet = args, argsSet
 	}
-	c.setupEnvAndFlags(c.cfg)
-	c.Cmd.Flags().Visit(func(arg0 *pflag.Flag) {
-		if arg0.Name == "help" {
-			os.Exit(0)
+	if c.fields == nil {
+		c.setup()
+	}
+	if err := c.runHook(c.hooks.BeforeLoad); err != nil {
+		return nil, err
+	}
+	if !c.argsSet && len(os.Args) > 1 {
+		c.Args = os.Args[1:] // the one implicit read, see Execute
+	}
+	if c.flagSet != nil {
+		if err := c.parseFlagSet(); err != nil {
+			return nil, err
 		}
-	})
-
-	if len(os.Args) > 1 && len(c.Args) == 0 {
-		c.Args = os.Args[1:]
+	} else {
+		target, flags, err := c.Cmd.Find(c.Args)
+		if err != nil {
+			target = c.Cmd
+		}
+		target.ParseFlags(flags)
+		c.target = target
+	}
+	if errs := c.applyEnv(); len(errs) > 0 {
+		return nil, errs
 	}
-	_, flags, _ := c.Cmd.Find(c.Args)
-	c.Cmd.ParseFlags(flags)
 	configFile := c.Viper.GetString("config")
+	if configFile == "" {
+		configFile = c.findConfig()
+	}
 	if configFile != "" {
-		_, err := os.Stat(configFile)
+		_, err := c.fs().Stat(configFile)
 		if err != nil {
 			return nil, err
 		}
+		c.Viper.SetFs(c.fs())
 		c.Viper.SetConfigFile(configFile) // name of config file
 		// Find and read the config file
 		if err = c.Viper.ReadInConfig(); err != nil { // Handle errors reading the config file
 			return nil, err
 		}
+		c.file = viper.New()
+		c.file.SetFs(c.fs())
+		c.file.SetConfigFile(configFile)
+		if err = c.file.ReadInConfig(); err != nil {
+			return nil, err
+		}
 		if err = c.Viper.Unmarshal(c.cfg); err != nil { // Handle errors reading the config file
 			return nil, err
 		}
+		if err = c.applyKeys(); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.applyDeprecated(); err != nil {
+		return nil, err
+	}
+	if err := c.checkUnknown(configFile); err != nil {
+		return nil, err
 	}
 	var err error
 	if err = c.getCfg(c.cfg); err != nil {
 		return c.cfg, err
 	}
-	return c.cfg, nil
+	if err = c.bindArgs(c.positionalArgs(c.Cmd)); err != nil {
+		return c.cfg, err
+	}
+	for _, sub := range c.active() {
+		if err = sub.load(); err != nil {
+			return c.cfg, err
+		}
+	}
+	return c.cfg, c.runHook(c.hooks.AfterMerge)
+}
+
+// setup runs the Defaulter hooks and registers flags and env bindings for
+// every field.
+func (c *Config) setup() {
+	c.origin = map[string]Source{}
+	c.preset = map[string]interface{}{}
+	c.recordOrigin(SourceCode)
+	c.setDefaults()
+	c.recordOrigin(SourceDefault)
+	c.setupEnvAndFlags(c.cfg)
+	c.argUsage()
+	c.annotateConstraints()
+	for _, sub := range c.subs {
+		sub.setup()
+	}
 }
 
 // Parse is an alias for Execute().
@@ -113,12 +242,59 @@ func (c *Config) Parse() (interface{}, error) {
 }
 
 // Execute runs the command (if provided) and populates the config struct.
+// It never exits the process: --help returns ErrHelpRequested instead.
+// Unless SetArgs, SetEnv, SetOutput or SetFs were called it uses os.Args[1:],
+// the process environment, os.Stdout/os.Stderr and the OS filesystem. Falling
+// back to os.Args keeps existing programs working; code that must not touch
+// process state, like tests and libraries, calls SetArgs (or WithArgs), where
+// nil or empty args mean no args.
 func (c *Config) Execute() (interface{}, error) {
+	return c.ExecuteContext(context.Background())
+}
+
+func (c *Config) execute(ctx context.Context) (interface{}, error) {
 	cfg, err := c.parse()
 	if err != nil {
 		return cfg, err
 	}
-	return c.cfg, c.Cmd.Execute()
+	if c.Viper.GetBool("help-env") {
+		return c.cfg, c.WriteEnvHelp(c.stdout())
+	}
+	if c.Viper.GetBool("help-all") {
+		c.target.Help()
+		return c.cfg, ErrHelpRequested
+	}
+	if format := c.Viper.GetString("print-config"); format != "" {
+		return c.cfg, c.PrintConfig(c.stdout(), Format(format), c.Viper.GetBool("print-config-sources"))
+	}
+	running.Store(c.Cmd.Root(), c.Context(ctx))
+	defer running.Delete(c.Cmd.Root())
+	// cobra reads os.Args when given nil.
+	c.Cmd.SetArgs(append([]string{}, c.Args...))
+	cmd, err := c.Cmd.ExecuteC()
+	if err == nil && cmd.Flags().Lookup("help") != nil {
+		if help, _ := cmd.Flags().GetBool("help"); help {
+			return c.cfg, ErrHelpRequested
+		}
+	}
+	return c.cfg, err
+}
+
+// builtinFlags are registered by addBuiltins on every Config.
+var builtinFlags = []string{"config", "print-config", "print-config-sources", "help-env", "help-all"}
+
+// addBuiltins registers the flags every Config has.
+func (c *Config) addBuiltins() {
+	c.flags().String("config", "", "The configuration file")
+	c.Viper.BindPFlag("config", c.flags().Lookup("config"))
+	c.flags().String("print-config", "", "Print the effective configuration (yaml, json, toml or env) and exit")
+	c.Viper.BindPFlag("print-config", c.flags().Lookup("print-config"))
+	c.flags().Bool("print-config-sources", false, "Annotate --print-config with where each value came from")
+	c.Viper.BindPFlag("print-config-sources", c.flags().Lookup("print-config-sources"))
+	c.flags().Bool("help-env", false, "List the environment variables read and exit")
+	c.Viper.BindPFlag("help-env", c.flags().Lookup("help-env"))
+	c.flags().Bool("help-all", false, "Show help including advanced options")
+	c.Viper.BindPFlag("help-all", c.flags().Lookup("help-all"))
 }
 
 // SilenceUsage will not print the help screen on error.
@@ -131,14 +307,69 @@ func (c *Config) SilenceErrors() {
 	c.Cmd.SilenceErrors = true
 }
 
-// Reset flags/command to reuse.
+// Reset flags to reuse. Subcommands, and flags the program added to the
+// commands itself, are kept.
 func (c *Config) Reset() {
-	c.Cmd.ResetCommands()
-	c.Cmd.ResetFlags()
-	c.Viper = viper.New()
-	c.Args = nil
-	c.Cmd.PersistentFlags().String("config", "", "The configuration file")
-	c.Viper.BindPFlag("config", c.Cmd.PersistentFlags().Lookup("config"))
+	// Note every command's flags before any are reset: a subcommand's
+	// inherited flags are told apart by its parents' persistent flags.
+	all := c.all()
+	restore := make([]func(), len(all))
+	for i, cc := range all {
+		restore[i] = cc.keepFlags()
+	}
+	for i, cc := range all {
+		cc.Cmd.ResetFlags()
+		restore[i]()
+		cc.Viper = viper.New()
+		cc.Args = nil
+		cc.argsSet = false
+		cc.fields = nil
+		cc.positional = nil
+		cc.file = nil
+	}
+	if c.parent == nil {
+		c.addBuiltins()
+	}
+}
+
+// keepFlags notes the flags of c's command that c didn't register, and
+// returns a func that adds them back once the command's flags are reset.
+func (c *Config) keepFlags() func() {
+	own := c.own()
+	if c.parent == nil {
+		for _, name := range builtinFlags {
+			own[name] = true
+		}
+	}
+	var local, persistent []*pflag.Flag
+	c.Cmd.PersistentFlags().VisitAll(func(f *pflag.Flag) {
+		if !own[f.Name] {
+			persistent = append(persistent, f)
+		}
+	})
+	c.Cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if !own[f.Name] && c.Cmd.PersistentFlags().Lookup(f.Name) == nil && !inherited(c.Cmd, f.Name) {
+			local = append(local, f)
+		}
+	})
+	return func() {
+		for _, f := range persistent {
+			c.Cmd.PersistentFlags().AddFlag(f)
+		}
+		for _, f := range local {
+			c.Cmd.Flags().AddFlag(f)
+		}
+	}
+}
+
+// inherited reports whether a parent of cmd has a persistent flag name.
+func inherited(cmd *cobra.Command, name string) bool {
+	for p := cmd.Parent(); p != nil; p = p.Parent() {
+		if p.PersistentFlags().Lookup(name) != nil {
+			return true
+		}
+	}
+	return false
 }
 
 /* NOTE: Due to a bug in Viper, all boolean flags MUST DEFAULT TO FALSE.
@@ -149,29 +380,32 @@ func (c *Config) Reset() {
 func (c *Config) getCfg(gCfg interface{}) error {
 	return eachSubField(gCfg, func(parent reflect.Value, subFieldName string, crumbs []string) error {
 		p := strings.Join(crumbs, "")
-		envStr := envString(p, subFieldName)
-		flagStr := flagString(p, subFieldName)
+		flagStr := c.flagFor(crumbs, subFieldName)
+		if f := c.fieldAt(strings.Join(append(append([]string{}, crumbs...), subFieldName), ".")); f != nil {
+			flagStr = f.flag
+		}
 
 		// eachSubField only calls this function if  subFieldName exists
 		// and can be set
 		subField := parent.FieldByName(subFieldName)
 		str := ""
-		if v := c.Viper.Get(envStr); v != nil {
-			str = envStr
-		} else if c.Viper.Get(flagStr) != nil {
+		if c.Viper.Get(flagStr) != nil {
 			str = flagStr
 		}
 		var v, ogV string
-		lup := c.Cmd.PersistentFlags().Lookup(strings.ToLower(str))
+		lup := c.flags().Lookup(strings.ToLower(str))
 		if lup != nil {
 			v = lup.Value.String()
 			ogV = v
 		}
+		// A flag given on the command line or by applyEnv wins even when it
+		// is zero (--port 0, CONF_DEBUG=false).
+		changed := lup != nil && lup.Changed
 		subFieldAsString := fmt.Sprintf("%v", subField)
 		// If the struct has a value filled in that wasn't provided
 		// as a flag, then set it as the flag value.
 		// This allows the required check to pass.
-		if subField.Type().Kind() != reflect.Bool && lup != nil {
+		if subField.Type().Kind() != reflect.Bool && lup != nil && !changed {
 			if !isZero(subField) && isZeroStr(v) {
 				v = subFieldAsString
 				lup.Value.Set(v)
@@ -180,7 +414,7 @@ func (c *Config) getCfg(gCfg interface{}) error {
 
 		// AHHHHHHHHHHHHHH. This line next line took forever.
 		// Don't "reset" the default value if it's been specified differently.
-		if lup != nil && lup.DefValue == ogV && ogV != "" && !isZeroStr(subFieldAsString) {
+		if lup != nil && !changed && lup.DefValue == ogV && ogV != "" && !isZeroStr(subFieldAsString) {
 			return nil
 		}
 
@@ -188,34 +422,38 @@ func (c *Config) getCfg(gCfg interface{}) error {
 			switch subField.Type().Kind() {
 			case reflect.Bool:
 				v := c.Viper.GetBool(str)
-				subField.SetBool(v || subField.Bool()) // IsSet is broken with bools, see NOTE above ^^^
+				if changed {
+					subField.SetBool(v)
+				} else {
+					subField.SetBool(v || subField.Bool()) // IsSet is broken with bools, see NOTE above ^^^
+				}
 			case reflect.Int:
 				v := c.Viper.GetInt(str)
-				if v == 0 {
+				if v == 0 && !changed {
 					return nil
 				}
 				subField.SetInt(int64(v))
 			case reflect.Int64:
 				v := c.Viper.GetInt64(str)
-				if v == 0 {
+				if v == 0 && !changed {
 					return nil
 				}
 				subField.SetInt(v)
 			case reflect.String:
 				v = c.Viper.GetString(str)
-				if len(v) == 0 {
+				if len(v) == 0 && !changed {
 					return nil
 				}
 				subField.SetString(v)
 			case reflect.Float64:
 				v := c.Viper.GetFloat64(str)
-				if v == 0 {
+				if v == 0 && !changed {
 					return nil
 				}
 				subField.SetFloat(v)
 			case reflect.Float32:
 				v := c.Viper.GetFloat64(str)
-				if v == 0 {
+				if v == 0 && !changed {
 					return nil
 				}
 				subField.SetFloat(v)
@@ -224,7 +462,7 @@ func (c *Config) getCfg(gCfg interface{}) error {
 				if len(v) == 0 || len(v[0]) == 0 || v[0] == "[]" {
 					return nil
 				}
-				subField.Set(reflect.Zero(reflect.TypeOf(v)))
+				subField.Set(reflect.ValueOf(v))
 			default:
 				return fmt.Errorf("%s is unsupported by config @ %s.%s", subField.Type().String(), p, subFieldName)
 			}
@@ -235,56 +473,57 @@ func (c *Config) getCfg(gCfg interface{}) error {
 
 // Process env var overrides for all values
 func (c *Config) setupEnvAndFlags(gCfg interface{}) error {
-	// Supports fetching value from env for all config of type: int, float64, bool, and string
-	c.Viper.AutomaticEnv()
-	c.Viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
-	c.Viper.SetEnvPrefix(c.Cmd.Name())
+	// Env vars are layered onto the flags by applyEnv, see env.go.
 	return eachSubField(gCfg, func(parent reflect.Value, subFieldName string, crumbs []string) error {
 		p := strings.Join(crumbs, "")
-		envStr := envString(p, subFieldName)
-		flagStr := flagString(p, subFieldName)
-		c.Viper.BindEnv(envStr)
+		f := c.newField(parent, subFieldName, crumbs)
+		flagStr, short := f.flag, f.tag.Get("short")
+		if _, ok := f.tag.Lookup("arg"); ok {
+			c.addArg(f)
+			return nil
+		}
+		if c.flags().Lookup(flagStr) != nil {
+			return nil // a collision, reported by checkCollisions
+		}
+		if len(short) == 1 && c.flags().ShorthandLookup(short) != nil {
+			short = ""
+		}
+		c.fields = append(c.fields, f)
 
 		subField, _ := parent.Type().FieldByName(subFieldName)
 
-		desc := subField.Tag.Get("desc")
-		if desc == "" {
-			desc = subField.Tag.Get("description")
-		}
-		_def := subField.Tag.Get("def")
-		if _def == "" {
-			_def = subField.Tag.Get("default")
-		}
+		desc := f.desc()
+		_def := f.defaultTag()
 		_, req := subField.Tag.Lookup("required")
 		switch subField.Type.Kind() {
 		case reflect.Bool:
-			c.Cmd.PersistentFlags().Bool(flagStr, false, desc)
+			c.flags().BoolP(flagStr, short, false, desc)
 		case reflect.Int:
 			var def int
 			if b, err := strconv.ParseInt(_def, 10, 32); err == nil {
 				def = int(b)
 			}
-			c.Cmd.PersistentFlags().Int(flagStr, def, desc)
+			c.flags().IntP(flagStr, short, def, desc)
 		case reflect.Int64:
 			var def int64
 			if b, err := strconv.ParseInt(_def, 10, 64); err == nil {
 				def = b
 			}
-			c.Cmd.PersistentFlags().Int64(flagStr, def, desc)
+			c.flags().Int64P(flagStr, short, def, desc)
 		case reflect.String:
-			c.Cmd.PersistentFlags().String(flagStr, _def, desc)
+			c.flags().StringP(flagStr, short, _def, desc)
 		case reflect.Float32:
 			var def float64
 			if b, err := strconv.ParseFloat(_def, 32); err == nil {
 				def = b
 			}
-			c.Cmd.PersistentFlags().Float64(flagStr, def, desc)
+			c.flags().Float64P(flagStr, short, def, desc)
 		case reflect.Float64:
 			var def float64
 			if b, err := strconv.ParseFloat(_def, 64); err == nil {
 				def = b
 			}
-			c.Cmd.PersistentFlags().Float64(flagStr, def, desc)
+			c.flags().Float64P(flagStr, short, def, desc)
 		case reflect.Slice:
 			def := strings.Split(_def, ",")
 			if len(def[0]) == 0 {
@@ -293,14 +532,16 @@ func (c *Config) setupEnvAndFlags(gCfg interface{}) error {
 			if subField.Type.Elem().Kind() != reflect.String {
 				return fmt.Errorf("%s is unsupported by config @ %s.%s", subField.Type.String(), p, subFieldName)
 			}
-			c.Cmd.PersistentFlags().StringSlice(flagStr, def, desc)
+			c.flags().StringSliceP(flagStr, short, def, desc)
 		default:
 			return fmt.Errorf("%s is unsupported by config @ %s.%s", subField.Type.String(), p, subFieldName)
 		}
 		if req {
-			c.Cmd.MarkPersistentFlagRequired(flagStr)
+			cobra.MarkFlagRequired(c.flags(), flagStr)
 		}
-		c.Viper.BindPFlag(flagStr, c.Cmd.PersistentFlags().Lookup(flagStr))
+		c.flags().Lookup(flagStr).Hidden = f.hidden() || !f.allows(SourceFlag)
+		c.Viper.BindPFlag(flagStr, c.flags().Lookup(flagStr))
+		c.bindDeprecated(f)
 		return nil
 	})
 
@@ -339,27 +580,89 @@ func eachSubField(i interface{}, fn func(reflect.Value, string, []string) error,
 	return nil
 }
 
-func (c *Config) checkRequiredFlags(flags *pflag.FlagSet) error {
-	requiredError := false
-	flagName := ""
+// eachStruct calls fn with the parent struct and then with every nested struct
+// below it, following the same rules as eachSubField.
+func eachStruct(i interface{}, fn func(reflect.Value, []string) error, crumbs ...string) error {
+	t := reflect.ValueOf(i)
+	if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return errors.New("eachStruct can only be called on a pointer-to-struct")
+	}
+	if err := fn(t, crumbs); err != nil {
+		return err
+	}
 
-	flags.VisitAll(func(flag *pflag.Flag) {
-		requiredAnnotation := flag.Annotations[cobra.BashCompOneRequiredFlag]
-		if len(requiredAnnotation) == 0 {
-			return
+	t = t.Elem()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if t.Type().Field(i).Tag.Get("flag") == "false" {
+			continue
 		}
-
-		flagRequired := requiredAnnotation[0] == "true"
-		val := c.Viper.Get(flag.Name)
-		if flagRequired && (!flag.Changed && isZero(val)) {
-			requiredError = true
-			flagName = flag.Name
+		if field.Kind() == reflect.Struct && field.CanSet() {
+			if err := eachStruct(field.Addr().Interface(), fn, append(crumbs, t.Type().Field(i).Name)...); err != nil {
+				return err
+			}
 		}
-	})
+	}
+	return nil
+}
 
-	if requiredError {
-		return fmt.Errorf("Required flag `%s` has not been set", flagName)
+// checkShape reports a cfg that isn't a pointer to a struct, or that has a
+// field of a type no flag can hold.
+func checkShape(cfg interface{}) error {
+	t := reflect.TypeOf(cfg)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config must be a pointer to a struct, got %T", cfg)
 	}
+	return checkFields(t.Elem(), nil)
+}
 
+func checkFields(t reflect.Type, crumbs []string) error {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" || sf.Tag.Get("flag") == "false" {
+			continue
+		}
+		path := strings.Join(append(append([]string{}, crumbs...), sf.Name), ".")
+		switch sf.Type.Kind() {
+		case reflect.Struct:
+			if err := checkFields(sf.Type, append(crumbs, sf.Name)); err != nil {
+				return err
+			}
+			continue
+		case reflect.Bool, reflect.Int, reflect.Int64, reflect.String, reflect.Float32, reflect.Float64:
+		case reflect.Slice:
+			if sf.Type.Elem().Kind() != reflect.String {
+				return fmt.Errorf("%s is unsupported by config @ %s", sf.Type.String(), path)
+			}
+		default:
+			return fmt.Errorf("%s is unsupported by config @ %s", sf.Type.String(), path)
+		}
+		if err := checkArgTag(sf, path); err != nil {
+			return err
+		}
+		if err := checkSourcesTag(sf, path); err != nil {
+			return err
+		}
+		if err := checkAliasTag(sf, path); err != nil {
+			return err
+		}
+		if short, ok := sf.Tag.Lookup("short"); ok && (len(short) != 1 || short[0] > unicode.MaxASCII) {
+			return fmt.Errorf("invalid short tag %q @ %s, expected one ASCII character", short, path)
+		}
+	}
 	return nil
 }
+
+// checkRequired reports every required field that no layer has set. A zero
+// value counts as set if it was given explicitly (--port 0, CONF_DEBUG=false),
+// an empty list never does.
+func (c *Config) checkRequired() Errors {
+	var errs Errors
+	for _, f := range c.fields {
+		empty := f.value.Kind() == reflect.Slice && f.value.Len() == 0
+		if f.required() && (c.source(f) == SourceNone || empty) {
+			errs = append(errs, c.fieldError(f, ErrRequired))
+		}
+	}
+	return errs
+}
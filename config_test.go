@@ -1,14 +1,26 @@
 package config
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
 	"os"
 	"reflect"
 	"strings"
 	"testing"
+	"testing/quick"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
@@ -295,7 +307,7 @@ func TestConfig(t *testing.T) {
 			os.Setenv(vars[0], vars[1])
 		}
 		if len(tc.conf) > 0 {
-			f, _ := ioutil.TempFile("", string(ti))
+			f, _ := ioutil.TempFile("", fmt.Sprint(ti))
 			newName := f.Name() + ".json"
 			defer os.Remove(newName)
 			f.Write([]byte(tc.conf))
@@ -556,6 +568,1774 @@ func TestCmdArgs(t *testing.T) {
 	}
 }
 
+func TestRequiredErrors(t *testing.T) {
+	cobCmd := &cobra.Command{
+		Use:           "test",
+		Long:          "test desc",
+		Run:           func(cmd *cobra.Command, args []string) {},
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	type s struct {
+		Addr     string `required:"true"`
+		Password string `required:"true" secret:"true"`
+		Log      struct {
+			Level string `required:"true"`
+		}
+	}
+	cfg := s{Password: "hunter2"}
+	c := NewWithCommand(cobCmd, &cfg)
+	c.SetArgs([]string{})
+	_, err := c.Execute()
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected Errors, got %v", err)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %d: %v", len(errs), err)
+	}
+	exp := FieldError{Path: "Addr", Flag: "--addr", Env: "TEST_ADDR", Key: "addr", Source: SourceNone, Err: ErrRequired}
+	if *errs[0] != exp {
+		t.Errorf("Got %+v, expected %+v", *errs[0], exp)
+	}
+	exp = FieldError{Path: "Log.Level", Flag: "--log-level", Env: "TEST_LOG_LEVEL", Key: "log.level", Source: SourceNone, Err: ErrRequired}
+	if *errs[1] != exp {
+		t.Errorf("Got %+v, expected %+v", *errs[1], exp)
+	}
+	if !errors.Is(err, ErrRequired) {
+		t.Error("errors.Is(err, ErrRequired) should be true")
+	}
+}
+
+type hookTLS struct {
+	Cert string
+	Key  string
+}
+
+func (t *hookTLS) Validate() error {
+	if (t.Cert == "") != (t.Key == "") {
+		return errors.New("cert and key must both be set")
+	}
+	return nil
+}
+
+type hookStruct struct {
+	Workers int
+	TLS     hookTLS
+}
+
+func (h *hookStruct) SetDefaults() {
+	h.Workers = 4
+}
+
+func TestHooks(t *testing.T) {
+	tests := []struct {
+		args    []string
+		workers int
+		errPath string
+	}{
+		{[]string{}, 4, ""},
+		{[]string{"--workers", "8"}, 8, ""},
+		{[]string{"--tls-cert", "cert.pem"}, 4, "TLS"},
+		{[]string{"--tls-cert", "cert.pem", "--tls-key", "key.pem"}, 4, ""},
+	}
+	for ti, test := range tests {
+		cobCmd := &cobra.Command{
+			Use:           "test",
+			Run:           func(cmd *cobra.Command, args []string) {},
+			SilenceUsage:  true,
+			SilenceErrors: true,
+		}
+		cfg := hookStruct{}
+		c := NewWithCommand(cobCmd, &cfg)
+		c.SetArgs(test.args)
+		_, err := c.Execute()
+		var serr *StructError
+		if test.errPath == "" && err != nil {
+			t.Errorf("Test %d) Shouldn't have errored: %v", ti, err)
+		} else if test.errPath != "" && (!errors.As(err, &serr) || serr.Path != test.errPath) {
+			t.Errorf("Test %d) Expected error for %s, got %v", ti, test.errPath, err)
+		}
+		if cfg.Workers != test.workers {
+			t.Errorf("Test %d) Expected %d workers, got %d", ti, test.workers, cfg.Workers)
+		}
+	}
+}
+
+type zeroDefaults struct {
+	Port  int  `required:"true"`
+	Debug bool `required:"true"`
+}
+
+func (z *zeroDefaults) SetDefaults() {
+	z.Port, z.Debug = 80, true
+}
+
+func TestRequiredZero(t *testing.T) {
+	type s struct {
+		Port  int  `required:"true"`
+		Debug bool `required:"true"`
+	}
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/test.yaml", []byte("port: 8080\ndebug: true\n"), 0644)
+	tests := []struct {
+		args       []string
+		env        []string
+		preset     s
+		shouldPass bool
+		expected   s
+	}{
+		{[]string{}, nil, s{}, false, s{}},
+		{[]string{"--port", "0"}, nil, s{}, false, s{}},
+		{[]string{"--port", "0"}, []string{"TEST_DEBUG=false"}, s{}, true, s{}},
+		{[]string{"--debug"}, []string{"TEST_PORT=0"}, s{}, true, s{Debug: true}},
+		{[]string{"--debug"}, nil, s{Port: 8080}, true, s{Port: 8080, Debug: true}},
+		{[]string{"--port", "0", "--debug=false"}, nil, s{Port: 8080, Debug: true}, true, s{}},
+		{[]string{"--config", "/test.yaml", "--port", "0"}, []string{"TEST_DEBUG=false"}, s{}, true, s{}},
+		{[]string{"--config", "/test.yaml"}, []string{"TEST_PORT=0"}, s{}, true, s{Debug: true}},
+	}
+	for ti, test := range tests {
+		for _, v := range test.env {
+			vars := strings.Split(v, "=")
+			os.Setenv(vars[0], vars[1])
+		}
+		cobCmd := &cobra.Command{
+			Use:           "test",
+			Run:           func(cmd *cobra.Command, args []string) {},
+			SilenceUsage:  true,
+			SilenceErrors: true,
+		}
+		cfg := test.preset
+		c := NewWithCommand(cobCmd, &cfg)
+		c.SetFs(fs)
+		c.SetArgs(test.args)
+		if _, err := c.Execute(); err == nil && !test.shouldPass {
+			t.Errorf("Test %d) Should have errored.", ti)
+		} else if err != nil && test.shouldPass {
+			t.Errorf("Test %d) Shouldn't have errored: %v", ti, err)
+		} else if err == nil && cfg != test.expected {
+			t.Errorf("Test %d) Expected %+v, got %+v", ti, test.expected, cfg)
+		}
+		for _, v := range test.env {
+			os.Unsetenv(strings.Split(v, "=")[0])
+		}
+	}
+
+	cfg := zeroDefaults{}
+	c := New("test", "", &cfg)
+	c.SetEnv(map[string]string{"TEST_DEBUG": "false"})
+	c.SetArgs([]string{"--port", "0"})
+	if _, err := c.Execute(); err != nil || cfg != (zeroDefaults{}) {
+		t.Errorf("Expected --port 0 and TEST_DEBUG=false to override the defaults, got %+v, %v", cfg, err)
+	}
+}
+
+func TestConstraints(t *testing.T) {
+	type s struct {
+		Addr      string
+		Socket    string `conflicts:"Addr"`
+		Mode      string
+		CACert    string `required_if:"Mode=tls"`
+		Token     string `group:"auth,oneof"`
+		TokenFile string `group:"auth,oneof"`
+		Level     string `enum:"debug,info"`
+		Port      int    `min:"1" max:"65535"`
+		TLS       struct {
+			Cert string `requires:"Key"`
+			Key  string
+		}
+	}
+	tests := []struct {
+		args  []string
+		env   []string
+		rules []string
+	}{
+		{[]string{"--token", "t"}, nil, nil},
+		{[]string{}, []string{"TEST_TOKEN_FILE=/t"}, nil},
+		{[]string{}, nil, []string{"group"}},
+		{[]string{"--token", "t", "--socket", "s", "--addr", "a"}, nil, []string{"conflicts"}},
+		{[]string{"--token", "t", "--mode", "tls"}, nil, []string{"required_if"}},
+		{[]string{"--token", "t", "--mode", "tls", "--ca-cert", "ca"}, nil, nil},
+		{[]string{"--tls-cert", "c"}, nil, []string{"requires", "group"}},
+		{[]string{"--tls-cert", "c"}, []string{"TEST_TLS_KEY=k", "TEST_TOKEN=t"}, nil},
+		{[]string{"--token", "t", "--level", "info"}, nil, nil},
+		{[]string{"--token", "t", "--level", "loud"}, nil, []string{"enum"}},
+		{[]string{"--token", "t", "--port", "0"}, nil, []string{"min"}},
+		{[]string{"--token", "t", "--port", "70000"}, nil, []string{"max"}},
+		{[]string{"--token", "t", "--port", "443"}, nil, nil},
+	}
+	for ti, test := range tests {
+		for _, v := range test.env {
+			vars := strings.Split(v, "=")
+			os.Setenv(vars[0], vars[1])
+		}
+		cobCmd := &cobra.Command{
+			Use:           "test",
+			Run:           func(cmd *cobra.Command, args []string) {},
+			SilenceUsage:  true,
+			SilenceErrors: true,
+		}
+		cfg := s{}
+		c := NewWithCommand(cobCmd, &cfg)
+		c.SetArgs(test.args)
+		_, err := c.Execute()
+		var errs Errors
+		errors.As(err, &errs)
+		if len(errs) != len(test.rules) {
+			t.Errorf("Test %d) Expected %d errors, got %v", ti, len(test.rules), err)
+		} else {
+			for i, e := range errs {
+				var cerr *ConstraintError
+				if !errors.As(e, &cerr) || cerr.Rule != test.rules[i] {
+					t.Errorf("Test %d) Expected %s error, got %v", ti, test.rules[i], e)
+				}
+			}
+		}
+		for _, v := range test.env {
+			os.Unsetenv(strings.Split(v, "=")[0])
+		}
+	}
+}
+
+func TestStrict(t *testing.T) {
+	type s struct {
+		Addr string
+		Log  struct {
+			Level string
+		}
+	}
+	f, _ := ioutil.TempFile("", "strict")
+	name := f.Name() + ".yaml"
+	defer os.Remove(name)
+	f.Write([]byte("addr: localhost\nlog:\n  levle: debug\n"))
+	f.Close()
+	os.Rename(f.Name(), name)
+	os.Setenv("TEST_ADRR", "localhost")
+	defer os.Unsetenv("TEST_ADRR")
+
+	cobCmd := &cobra.Command{
+		Use:           "test",
+		Run:           func(cmd *cobra.Command, args []string) {},
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	cfg := s{}
+	c := NewWithCommand(cobCmd, &cfg)
+	c.Strict()
+	c.SetArgs([]string{"--config", name})
+	_, err := c.Execute()
+	if err == nil {
+		t.Fatal("Strict mode should have errored")
+	}
+	expected := []string{
+		name + ":3: unknown key log.levle, did you mean log.level?",
+		"unknown env var TEST_ADRR, did you mean TEST_ADDR?",
+	}
+	if err.Error() != strings.Join(expected, "\n") {
+		t.Errorf("Got %q, expected %q", err.Error(), strings.Join(expected, "\n"))
+	}
+
+	var log bytes.Buffer
+	cfg = s{}
+	c = New("test", "", &cfg)
+	c.StrictWarn()
+	c.SetLogger(slog.New(slog.NewTextHandler(&log, nil)))
+	c.SetArgs([]string{"--config", name})
+	if _, err := c.Execute(); err != nil {
+		t.Fatalf("StrictWarn shouldn't error: %v", err)
+	}
+	for _, exp := range expected {
+		if !strings.Contains(log.String(), `level=WARN msg="unknown option" error="`+exp) {
+			t.Errorf("Expected warning %q in %q", exp, log.String())
+		}
+	}
+}
+
+func TestAliases(t *testing.T) {
+	type s struct {
+		Log struct {
+			Level string `alias:"Verbosity" deprecated:"use --log-level; removed in v3"`
+		}
+		Old string `deprecated:"no longer used"`
+	}
+	tests := []struct {
+		args     []string
+		env      []string
+		conf     string
+		level    string
+		warnings int
+	}{
+		{[]string{"--log-level", "info"}, nil, "", "info", 0},
+		{[]string{"--log-verbosity", "debug"}, nil, "", "debug", 1},
+		{[]string{}, []string{"TEST_LOG_VERBOSITY=warn"}, "", "warn", 1},
+		{[]string{"--old", "x"}, nil, `{"log": {"verbosity": "error"}}`, "error", 2},
+	}
+	for ti, test := range tests {
+		for _, v := range test.env {
+			vars := strings.Split(v, "=")
+			os.Setenv(vars[0], vars[1])
+		}
+		args := test.args
+		if len(test.conf) > 0 {
+			f, _ := ioutil.TempFile("", "alias")
+			name := f.Name() + ".json"
+			defer os.Remove(name)
+			f.Write([]byte(test.conf))
+			f.Close()
+			os.Rename(f.Name(), name)
+			args = append(args, "--config", name)
+		}
+		cobCmd := &cobra.Command{
+			Use:           "test",
+			Run:           func(cmd *cobra.Command, args []string) {},
+			SilenceUsage:  true,
+			SilenceErrors: true,
+		}
+		var buf bytes.Buffer
+		cfg := s{}
+		c := NewWithCommand(cobCmd, &cfg)
+		c.SetLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+		c.SetArgs(args)
+		if _, err := c.Execute(); err != nil {
+			t.Errorf("Test %d) Shouldn't have errored: %v", ti, err)
+		}
+		if cfg.Log.Level != test.level {
+			t.Errorf("Test %d) Expected level %q, got %q", ti, test.level, cfg.Log.Level)
+		}
+		if n := strings.Count(buf.String(), "deprecated option"); n != test.warnings {
+			t.Errorf("Test %d) Expected %d warnings, got %d:\n%s", ti, test.warnings, n, buf.String())
+		}
+		if !cobCmd.PersistentFlags().Lookup("log-verbosity").Hidden || !cobCmd.PersistentFlags().Lookup("old").Hidden {
+			t.Errorf("Test %d) Deprecated flags should be hidden", ti)
+		}
+		for _, v := range test.env {
+			os.Unsetenv(strings.Split(v, "=")[0])
+		}
+	}
+
+	// An old flag name derives the env var and file key the old field had.
+	type kebab struct {
+		Log struct {
+			MaxSize int `alias:"max-bytes"`
+		}
+	}
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/test.yaml", []byte("log:\n  maxbytes: 3\n"), 0644)
+	for ti, test := range []struct {
+		args []string
+		env  map[string]string
+	}{
+		{[]string{"--log-max-bytes", "3"}, nil},
+		{[]string{}, map[string]string{"TEST_LOG_MAX_BYTES": "3"}},
+		{[]string{"--config", "/test.yaml"}, nil},
+	} {
+		cfg := kebab{}
+		c := New("test", "", &cfg, WithArgs(test.args...), WithEnv(test.env), WithFs(fs))
+		c.SetLogger(slog.New(slog.NewTextHandler(ioutil.Discard, nil)))
+		if _, err := c.Execute(); err != nil || cfg.Log.MaxSize != 3 {
+			t.Errorf("Kebab %d) Expected 3, got %d, %v", ti, cfg.Log.MaxSize, err)
+		}
+	}
+	var md bytes.Buffer
+	New("test", "", &kebab{}).WriteMarkdown(&md)
+	if !strings.Contains(md.String(), "`--log-max-bytes`, `TEST_LOG_MAX_BYTES`, `log.maxbytes`") {
+		t.Errorf("Expected the old names in:\n%s", md.String())
+	}
+	if _, err := NewTyped[struct {
+		Level string `alias:"old level"`
+	}]("test", ""); err == nil || !strings.Contains(err.Error(), `invalid alias "old level" @ Level`) {
+		t.Errorf("Expected an invalid alias error, got %v", err)
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	type s struct {
+		Addr     string
+		Password string `secret:"true"`
+		Ports    []string
+		Log      struct {
+			Level string  `default:"info"`
+			Rate  float64 `default:"2"`
+		}
+	}
+	tests := []struct {
+		format   Format
+		sources  bool
+		expected string
+	}{
+		{FormatYAML, false, `addr: "localhost"
+password: "[REDACTED]"
+ports: ["80", "443"]
+log:
+  level: "info"
+  rate: 2.0
+`},
+		{FormatTOML, true, `addr = "localhost" # flag
+password = "[REDACTED]" # env
+ports = ["80", "443"] # flag
+ 
+[log]
+level = "info" # default
+rate = 2.0 # default
+`},
+		{FormatEnv, false, `export TEST_ADDR='localhost'
+export TEST_PASSWORD='[REDACTED]'
+export TEST_PORTS='80,443'
+export TEST_LOG_LEVEL='info'
+export TEST_LOG_RATE='2'
+`},
+		{FormatJSON, false, `{
+  "addr": "localhost",
+  "log": {
+    "level": "info",
+    "rate": 2
+  },
+  "password": "[REDACTED]",
+  "ports": [
+    "80",
+    "443"
+  ]
+}
+`},
+	}
+	os.Setenv("TEST_PASSWORD", "hunter2")
+	defer os.Unsetenv("TEST_PASSWORD")
+	for ti, test := range tests {
+		cobCmd := &cobra.Command{
+			Use:           "test",
+			Run:           func(cmd *cobra.Command, args []string) {},
+			SilenceUsage:  true,
+			SilenceErrors: true,
+		}
+		cfg := s{}
+		c := NewWithCommand(cobCmd, &cfg)
+		c.SetArgs([]string{"--addr", "localhost", "--ports", "80,443"})
+		if _, err := c.Execute(); err != nil {
+			t.Fatalf("Test %d) Shouldn't have errored: %v", ti, err)
+		}
+		var buf bytes.Buffer
+		if err := c.PrintConfig(&buf, test.format, test.sources); err != nil {
+			t.Errorf("Test %d) Shouldn't have errored: %v", ti, err)
+		}
+		expected := strings.Replace(test.expected, "\n \n", "\n\n", -1)
+		if buf.String() != expected {
+			t.Errorf("Test %d) Got\n%s\nexpected\n%s", ti, buf.String(), expected)
+		}
+	}
+}
+
+func TestWriteSample(t *testing.T) {
+	cobCmd := &cobra.Command{
+		Use:           "test",
+		Run:           func(cmd *cobra.Command, args []string) {},
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	type s struct {
+		Addr     string `desc:"address to listen on" required:"true"`
+		Password string `secret:"true" default:"hunter2"`
+		Log      struct {
+			Level string `desc:"log level" default:"info" enum:"debug,info,error"`
+		}
+		Hook  hookStruct
+		Debug bool `hidden:"true"`
+	}
+	cfg := s{}
+	c := NewWithCommand(cobCmd, &cfg)
+	var buf bytes.Buffer
+	if err := c.WriteSample(&buf, FormatYAML); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# address to listen on
+# Required.
+addr: ""
+# Secret, prefer $TEST_PASSWORD.
+password: ""
+log:
+  # log level
+  # One of: debug, info, error.
+  level: "info"
+hook:
+  workers: 4
+  tls:
+    cert: ""
+    key: ""
+`
+	if buf.String() != expected {
+		t.Errorf("Got\n%s\nexpected\n%s", buf.String(), expected)
+	}
+	if err := c.WriteSample(&buf, FormatJSON); err == nil {
+		t.Error("Samples can't be written as JSON")
+	}
+}
+
+func TestSchema(t *testing.T) {
+	cobCmd := &cobra.Command{
+		Use:  "test",
+		Long: "test desc",
+		Run:  func(cmd *cobra.Command, args []string) {},
+	}
+	type s struct {
+		Addr  string   `desc:"address to listen on" required:"true"`
+		Port  int      `default:"80" min:"1" max:"65535"`
+		Ports []string `enum:"80,443"`
+		Level int      `enum:"1,2,3"`
+		Ratio float64  `enum:"0.5,1"`
+		Log   struct {
+			Level string `default:"info" enum:"debug,info"`
+		}
+	}
+	cfg := s{}
+	c := NewWithCommand(cobCmd, &cfg)
+	b, err := c.Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema interface{}
+	if err := json.Unmarshal(b, &schema); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"$schema":     schemaDraft,
+		"title":       "test",
+		"description": "test desc",
+		"type":        "object",
+		"required":    []interface{}{"addr"},
+		"properties": map[string]interface{}{
+			"addr": map[string]interface{}{"type": "string", "description": "address to listen on"},
+			"port": map[string]interface{}{"type": "integer", "default": 80.0, "minimum": 1.0, "maximum": 65535.0},
+			"ports": map[string]interface{}{
+				"type":  "array",
+				"items": map[string]interface{}{"type": "string", "enum": []interface{}{"80", "443"}},
+			},
+			"level": map[string]interface{}{"type": "integer", "enum": []interface{}{1.0, 2.0, 3.0}},
+			"ratio": map[string]interface{}{"type": "number", "enum": []interface{}{0.5, 1.0}},
+			"log": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"level": map[string]interface{}{"type": "string", "default": "info", "enum": []interface{}{"debug", "info"}},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(schema, expected) {
+		t.Errorf("Got\n%s", b)
+	}
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	cobCmd := &cobra.Command{
+		Use:  "test",
+		Long: "test desc",
+		Run:  func(cmd *cobra.Command, args []string) {},
+	}
+	type s struct {
+		Addr string `desc:"address | port" required:"true"`
+		Log  struct {
+			Level string `default:"info" alias:"Verbosity"`
+		}
+		Old int `deprecated:"no longer used"`
+	}
+	cfg := s{}
+	c := NewWithCommand(cobCmd, &cfg)
+	c.AddInitCommand()
+	serve := &cobra.Command{Use: "serve", Short: "Serve files"}
+	serve.Flags().Bool("dry", false, "print what would be served")
+	c.AddCommand(serve, &struct {
+		Dir string `desc:"root directory" default:"." required:"true"`
+	}{})
+	var buf bytes.Buffer
+	if err := c.WriteMarkdown(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := "# test\n\ntest desc\n\n```\ntest\n```\n\n## Options\n\n" +
+		"| Flag | Env | File key | Type | Default | Required | Description |\n" +
+		"|------|-----|----------|------|---------|----------|-------------|\n" +
+		"| `--addr` | `TEST_ADDR` | `addr` | string |  | yes | address \\| port |\n" +
+		"| `--log-level` | `TEST_LOG_LEVEL` | `log.level` | string | `\"info\"` |  | Deprecated names: `--log-verbosity`, `TEST_LOG_VERBOSITY`, `log.verbosity`. |\n" +
+		"| `--old` | `TEST_OLD` | `old` | int |  |  | **Deprecated:** no longer used |\n" +
+		"\n## Other flags\n\n" +
+		"- `--config`: The configuration file\n" +
+		"- `--help-all`: Show help including advanced options\n" +
+		"- `--help-env`: List the environment variables read and exit\n" +
+		"- `--print-config`: Print the effective configuration (yaml, json, toml or env) and exit\n" +
+		"- `--print-config-sources`: Annotate --print-config with where each value came from\n" +
+		"\n## Commands\n\n### test init\n\nWrite a sample config file\n\n```\ntest init [FILE]\n```\n" +
+		"\n- `--format`: yaml or toml (default from the file extension, else yaml)\n" +
+		"\n### test serve\n\nServe files\n\n```\ntest serve\n```\n\n" +
+		"| Flag | Env | File key | Type | Default | Required | Description |\n" +
+		"|------|-----|----------|------|---------|----------|-------------|\n" +
+		"| `--dir` | `TEST_SERVE_DIR` | `serve.dir` | string | `\".\"` | yes | root directory |\n" +
+		"\n- `--dry`: print what would be served\n"
+	if buf.String() != expected {
+		t.Errorf("Got\n%s\nexpected\n%s", buf.String(), expected)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	cobCmd := &cobra.Command{
+		Use:           "test",
+		Run:           func(cmd *cobra.Command, args []string) {},
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	type s struct {
+		Addr string `desc:"address"`
+		Log  struct {
+			Level string `default:"info"`
+		}
+		DB struct {
+			Host string
+		} `group:"Database"`
+	}
+	dir, _ := ioutil.TempDir("", "usage")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(dir+"/test.yaml", []byte("addr: from-file\n"), 0644)
+	cfg := s{}
+	c := NewWithCommand(cobCmd, &cfg)
+	c.AddConfigPath("/nonexistent", dir)
+	c.SetArgs([]string{})
+	if _, err := c.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != "from-file" {
+		t.Errorf("Expected addr from %s/test.yaml, got %q", dir, cfg.Addr)
+	}
+	usage := cobCmd.UsageString()
+	for _, exp := range []string{
+		"\nOptions:\n      --addr string   address [$TEST_ADDR]\n",
+		"\nLog options:\n      --log-level string   [$TEST_LOG_LEVEL] (default \"info\")\n",
+		"\nDatabase options:\n      --db-host string   [$TEST_DB_HOST]\n",
+		"\nOther flags:\n      --config string",
+		"\nConfig file:\n  --config FILE or $TEST_CONFIG\n  otherwise the first test.{",
+	} {
+		if !strings.Contains(usage, exp) {
+			t.Errorf("Usage should contain %q:\n%s", exp, usage)
+		}
+	}
+	var buf bytes.Buffer
+	c.WriteEnvHelp(&buf)
+	if !strings.Contains(buf.String(), "  TEST_LOG_LEVEL  (default \"info\")\n") {
+		t.Errorf("Unexpected env help:\n%s", buf.String())
+	}
+}
+
+func TestHermetic(t *testing.T) {
+	type s struct {
+		Addr string
+		Port int `default:"80"`
+		Log  struct {
+			Level string
+		}
+	}
+	tests := []struct {
+		args     []string
+		env      map[string]string
+		expected s
+		stdout   string
+		err      error
+	}{
+		{[]string{}, nil, s{Addr: "file", Port: 80}, "", nil},
+		{[]string{"--port", "8080"}, map[string]string{"TEST_PORT": "9090", "TEST_ADDR": "env"}, s{Addr: "env", Port: 8080}, "", nil},
+		{[]string{}, map[string]string{"TEST_LOG_LEVEL": "debug"}, s{Addr: "file", Port: 80}, "", nil},
+		{[]string{"--help"}, nil, s{Addr: "file", Port: 80}, "", ErrHelpRequested},
+		{[]string{"--print-config", "env"}, map[string]string{"TEST_PORT": "1"}, s{Addr: "file", Port: 1}, "export TEST_ADDR='file'\nexport TEST_PORT='1'\nexport TEST_LOG_LEVEL=''\n", nil},
+	}
+	tests[2].expected.Log.Level = "debug"
+	for ti, test := range tests {
+		ti, test := ti, test
+		t.Run(fmt.Sprint(ti), func(t *testing.T) {
+			t.Parallel()
+			cobCmd := &cobra.Command{
+				Use:           "test",
+				Run:           func(cmd *cobra.Command, args []string) {},
+				SilenceUsage:  true,
+				SilenceErrors: true,
+			}
+			fs := afero.NewMemMapFs()
+			afero.WriteFile(fs, "/etc/test.yaml", []byte("addr: file\n"), 0644)
+			var stdout, stderr bytes.Buffer
+			cfg := s{}
+			c := NewWithCommand(cobCmd, &cfg)
+			c.AddConfigPath("/etc")
+			c.SetFs(fs)
+			c.SetEnv(test.env)
+			c.SetOutput(&stdout, &stderr)
+			c.SetArgs(test.args)
+			if _, err := c.Execute(); err != test.err {
+				t.Fatalf("Expected error %v, got %v", test.err, err)
+			}
+			if cfg != test.expected {
+				t.Errorf("Expected %+v, got %+v", test.expected, cfg)
+			}
+			if stdout.String() != test.stdout {
+				t.Errorf("Expected stdout %q, got %q", test.stdout, stdout.String())
+			}
+			if test.err == ErrHelpRequested && !strings.Contains(stderr.String(), "Usage:") {
+				t.Errorf("Expected help on stderr, got %q", stderr.String())
+			}
+		})
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	type s struct {
+		Addr string
+		Port int
+	}
+	embedded := afero.NewMemMapFs()
+	afero.WriteFile(embedded, "/etc/test.yaml", []byte("addr: embedded\nport: 1\n"), 0644)
+	afero.WriteFile(embedded, "/etc/base.yaml", []byte("addr: embedded\n"), 0644)
+	tests := []struct {
+		local    map[string]string
+		expected s
+	}{
+		{map[string]string{}, s{Addr: "embedded", Port: 1}},
+		{map[string]string{"/etc/test.yaml": "addr: local\n"}, s{Addr: "local"}},
+	}
+	for ti, test := range tests {
+		cobCmd := &cobra.Command{
+			Use:           "test",
+			Run:           func(cmd *cobra.Command, args []string) {},
+			SilenceUsage:  true,
+			SilenceErrors: true,
+		}
+		local := afero.NewMemMapFs()
+		for name, data := range test.local {
+			afero.WriteFile(local, name, []byte(data), 0644)
+		}
+		fs := Overlay(embedded, local)
+		cfg := s{}
+		c := NewWithCommand(cobCmd, &cfg)
+		c.AddInitCommand()
+		c.AddConfigPath("/etc")
+		c.SetFs(fs)
+		c.SetEnv(map[string]string{})
+		c.SetArgs([]string{})
+		if _, err := c.Execute(); err != nil {
+			t.Fatalf("%d: %v", ti, err)
+		}
+		if cfg != test.expected {
+			t.Errorf("%d: Expected %+v, got %+v", ti, test.expected, cfg)
+		}
+
+		c.SetArgs([]string{"init", "/etc/base.yaml"})
+		if _, err := c.Execute(); err == nil {
+			t.Errorf("%d: init should not overwrite a file in the embedded layer", ti)
+		}
+		c.SetArgs([]string{"init", "/etc/new.yaml"})
+		if _, err := c.Execute(); err != nil {
+			t.Fatalf("%d: %v", ti, err)
+		}
+		if ok, _ := afero.Exists(local, "/etc/new.yaml"); !ok {
+			t.Errorf("%d: init should write to the top layer", ti)
+		}
+		if ok, _ := afero.Exists(embedded, "/etc/new.yaml"); ok {
+			t.Errorf("%d: init should not write to the embedded layer", ti)
+		}
+	}
+}
+
+func TestTyped(t *testing.T) {
+	type s struct {
+		Addr string `required:"true"`
+		Log  struct {
+			Level string `default:"info"`
+		}
+	}
+	c, err := NewTyped[s]("test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetEnv(map[string]string{"TEST_ADDR": "env"})
+	c.SetArgs([]string{"--log-level", "debug"})
+	cfg, err := c.Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg != c.Get() || cfg.Addr != "env" || cfg.Log.Level != "debug" {
+		t.Errorf("Unexpected config %+v", cfg)
+	}
+
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/etc/load.toml", []byte("addr = \"file\"\n"), 0644)
+	cfg, err = Load[s](WithArgs("--config", "/etc/load.toml"), WithEnv(map[string]string{}), WithFs(fs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != "file" || cfg.Log.Level != "info" {
+		t.Errorf("Unexpected config %+v", cfg)
+	}
+	_, err = Load[s](WithArgs(), WithEnv(map[string]string{}), WithOutput(ioutil.Discard, ioutil.Discard))
+	if !errors.Is(err, ErrRequired) {
+		t.Errorf("Expected ErrRequired, got %v", err)
+	}
+
+	type bad struct {
+		Sub struct {
+			Ports map[string]int
+		}
+	}
+	if _, err := NewTyped[bad]("test", ""); err == nil || !strings.Contains(err.Error(), "Sub.Ports") {
+		t.Errorf("Expected an error naming Sub.Ports, got %v", err)
+	}
+	if _, err := NewTyped[int]("test", ""); err == nil {
+		t.Error("Expected an error for a non-struct type")
+	}
+}
+
+func TestBindFlagSet(t *testing.T) {
+	type s struct {
+		Addr  string `required:"true"`
+		Debug bool
+		Log   struct {
+			Level string `default:"info" enum:"debug,info"`
+		}
+	}
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/etc/tool.yaml", []byte("addr: file\n"), 0644)
+	tests := []struct {
+		goFlags  bool
+		args     []string
+		env      map[string]string
+		expected s
+		err      error
+	}{
+		{false, []string{"--addr", "flag", "--debug", "--verbose"}, nil, s{Addr: "flag", Debug: true}, nil},
+		{true, []string{"-verbose", "-debug", "-config", "/etc/tool.yaml", "-log-level", "debug"}, map[string]string{"TOOL_ADDR": "env"}, s{Addr: "env", Debug: true}, nil},
+		{true, []string{}, map[string]string{"TOOL_LOG_LEVEL": "debug"}, s{}, ErrRequired},
+		{false, []string{"--help"}, nil, s{}, ErrHelpRequested},
+		{true, []string{"-h"}, nil, s{}, ErrHelpRequested},
+	}
+	tests[0].expected.Log.Level = "info"
+	tests[1].expected.Log.Level = "debug"
+	tests[2].expected.Log.Level = "debug"
+	for ti, test := range tests {
+		cfg := s{}
+		opts := []Option{WithEnv(test.env), WithFs(fs)}
+		var c *Config
+		var err error
+		if test.goFlags {
+			gfs := flag.NewFlagSet("tool", flag.ContinueOnError)
+			gfs.SetOutput(ioutil.Discard)
+			gfs.Bool("verbose", false, "not a config field")
+			c, err = BindGoFlagSet(gfs, &cfg, opts...)
+		} else {
+			pfs := pflag.NewFlagSet("tool", pflag.ContinueOnError)
+			pfs.SetOutput(ioutil.Discard)
+			pfs.Bool("verbose", false, "not a config field")
+			c, err = BindFlagSet(pfs, &cfg, opts...)
+		}
+		if err != nil {
+			t.Fatalf("%d: %v", ti, err)
+		}
+		err = c.ParseFlagSet(test.args)
+		if !errors.Is(err, test.err) && err != test.err {
+			t.Errorf("%d: Expected error %v, got %v", ti, test.err, err)
+		}
+		if test.err == nil && cfg != test.expected {
+			t.Errorf("%d: Expected %+v, got %+v", ti, test.expected, cfg)
+		}
+	}
+
+	// A flag set the program already parsed keeps its flags and args.
+	type pos struct {
+		Name string
+		Src  string `arg:"0" required:"true"`
+	}
+	args := []string{"--name", "n", "srcval"}
+	for _, goFlags := range []bool{false, true} {
+		cfg := pos{}
+		var c *Config
+		var err error
+		if goFlags {
+			gfs := flag.NewFlagSet("tool", flag.ContinueOnError)
+			c, err = BindGoFlagSet(gfs, &cfg, WithEnv(map[string]string{}))
+			gfs.Parse(args)
+		} else {
+			pfs := pflag.NewFlagSet("tool", pflag.ContinueOnError)
+			c, err = BindFlagSet(pfs, &cfg, WithEnv(map[string]string{}))
+			pfs.Parse(args)
+		}
+		if err == nil {
+			err = c.ParseFlagSet(nil)
+		}
+		if err != nil || cfg != (pos{Name: "n", Src: "srcval"}) {
+			t.Errorf("go flags %v: Expected the parsed flag set to be kept, got %+v, %v", goFlags, cfg, err)
+		}
+	}
+}
+
+func TestSubcommands(t *testing.T) {
+	type global struct {
+		Addr string `required:"true"`
+	}
+	type serve struct {
+		Port  int `default:"80"`
+		Debug bool
+		Log   struct {
+			Level string
+		}
+	}
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/etc/test.yaml", []byte("addr: file\nserve:\n  port: 82\n  log:\n    level: debug\n"), 0644)
+	tests := []struct {
+		args     []string
+		env      map[string]string
+		file     bool
+		ran      string
+		expected serve
+		err      error
+	}{
+		{[]string{"serve", "--port", "81", "--addr", "flag"}, map[string]string{"TEST_SERVE_DEBUG": "true"}, false, "serve flag", serve{Port: 81, Debug: true}, nil},
+		{[]string{"serve"}, nil, true, "serve file", serve{Port: 82}, nil},
+		{[]string{"serve", "--port", "81"}, nil, false, "", serve{Port: 81}, ErrRequired},
+		{[]string{"version"}, nil, false, "version", serve{}, nil},
+	}
+	tests[1].expected.Log.Level = "debug"
+	for ti, test := range tests {
+		var ran string
+		version := &cobra.Command{
+			Use: "version",
+			Run: func(cmd *cobra.Command, args []string) { ran = "version" },
+		}
+		cobCmd := &cobra.Command{
+			Use:           "test",
+			Run:           func(cmd *cobra.Command, args []string) {},
+			SilenceUsage:  true,
+			SilenceErrors: true,
+		}
+		cobCmd.AddCommand(version)
+		g, sv := global{}, serve{}
+		c := NewWithCommand(cobCmd, &g)
+		c.AddCommand(&cobra.Command{
+			Use: "serve",
+			RunE: func(cmd *cobra.Command, args []string) error {
+				ran = "serve " + g.Addr
+				return nil
+			},
+		}, &sv)
+		c.SetFs(fs)
+		if test.file {
+			c.AddConfigPath("/etc")
+		}
+		c.SetEnv(test.env)
+		c.SetArgs(test.args)
+		_, err := c.Execute()
+		if !errors.Is(err, test.err) {
+			t.Errorf("%d: Expected error %v, got %v", ti, test.err, err)
+		}
+		if ran != test.ran {
+			t.Errorf("%d: Expected %q to run, got %q", ti, test.ran, ran)
+		}
+		if sv != test.expected {
+			t.Errorf("%d: Expected %+v, got %+v", ti, test.expected, sv)
+		}
+	}
+
+	cobCmd := &cobra.Command{Use: "test", Run: func(cmd *cobra.Command, args []string) {}}
+	c := NewWithCommand(cobCmd, &global{})
+	sub := &cobra.Command{Use: "serve", Run: func(cmd *cobra.Command, args []string) {}}
+	c.AddCommand(sub, &serve{})
+	c.WriteEnvHelp(ioutil.Discard)
+	usage := sub.UsageString()
+	for _, exp := range []string{
+		"\nOptions:\n      --debug      [$TEST_SERVE_DEBUG]\n      --port int   [$TEST_SERVE_PORT] (default 80)\n",
+		"\nLog options:\n      --log-level string   [$TEST_SERVE_LOG_LEVEL]\n",
+		"\nGlobal Options:\n      --addr string   [$TEST_ADDR]\n",
+		"\n  --config FILE or $TEST_CONFIG\n",
+	} {
+		if !strings.Contains(usage, exp) {
+			t.Errorf("Usage should contain %q:\n%s", exp, usage)
+		}
+	}
+
+	// Running again keeps the flags the program added to its commands.
+	var dry, verbose bool
+	sub = &cobra.Command{Use: "serve", Run: func(cmd *cobra.Command, args []string) {}}
+	sub.Flags().BoolVar(&dry, "dry", false, "")
+	sv := serve{}
+	c = New("test", "", &global{})
+	c.Cmd.PersistentFlags().BoolVar(&verbose, "verbose", false, "")
+	c.AddCommand(sub, &sv)
+	c.SilenceErrors()
+	c.SetEnv(map[string]string{})
+	for run, args := range [][]string{
+		{"serve", "--dry", "--verbose", "--addr", "a", "--port", "1"},
+		{"serve", "--dry", "--verbose", "--addr", "b", "--port", "2"},
+	} {
+		dry, verbose = false, false
+		c.SetArgs(args)
+		if _, err := c.Execute(); err != nil || !dry || !verbose || sv.Port != run+1 {
+			t.Errorf("Run %d: Expected --dry, --verbose and --port %d, got %v, %v, %d, %v", run, run+1, dry, verbose, sv.Port, err)
+		}
+	}
+}
+
+func TestPositionalArgs(t *testing.T) {
+	type s struct {
+		Src   string   `arg:"0" required:"true"`
+		Dst   string   `arg:"1" default:"out"`
+		Files []string `arg:"rest"`
+		Count int      `arg:"2" min:"1" max:"3"`
+		Debug bool
+	}
+	tests := []struct {
+		args     []string
+		expected s
+		err      string
+	}{
+		{[]string{"a", "b", "2", "x", "y"}, s{Src: "a", Dst: "b", Count: 2, Files: []string{"x", "y"}}, ""},
+		{[]string{"--debug", "a"}, s{Src: "a", Dst: "out", Debug: true}, ""},
+		{[]string{}, s{Dst: "out"}, "Src (<SRC>): required option has not been set"},
+		{[]string{"a", "b", "4"}, s{Src: "a", Dst: "b", Count: 4}, "Count ([COUNT]): [COUNT] is 4, expected at most 3"},
+		{[]string{"a", "b", "0"}, s{Src: "a", Dst: "b"}, "Count ([COUNT]): [COUNT] is 0, expected at least 1"},
+		{[]string{"a", "b", "x"}, s{Src: "a", Dst: "b"}, `Count ([COUNT]): strconv.ParseInt: parsing "x": invalid syntax`},
+	}
+	for ti, test := range tests {
+		cobCmd := &cobra.Command{
+			Use:           "test",
+			Run:           func(cmd *cobra.Command, args []string) {},
+			SilenceUsage:  true,
+			SilenceErrors: true,
+		}
+		cfg := s{}
+		c := NewWithCommand(cobCmd, &cfg)
+		c.SetEnv(map[string]string{})
+		c.SetArgs(test.args)
+		_, err := c.Execute()
+		if fmt.Sprint(err) != test.err && !(err == nil && test.err == "") {
+			t.Errorf("%d: Expected error %q, got %v", ti, test.err, err)
+		}
+		if !reflect.DeepEqual(cfg, test.expected) {
+			t.Errorf("%d: Expected %+v, got %+v", ti, test.expected, cfg)
+		}
+		if use := cobCmd.UseLine(); use != "test <SRC> [DST] [COUNT] [FILES...]" {
+			t.Errorf("%d: Unexpected usage line %q", ti, use)
+		}
+	}
+
+	type modes struct {
+		Src  string `arg:"0"`
+		Mode string `arg:"1" enum:"fast,slow"`
+	}
+	for ti, args := range [][]string{{"a"}, {"a", "fast"}, {"a", "quick"}} {
+		cfg := modes{}
+		c := New("test", "", &cfg)
+		c.SetEnv(map[string]string{})
+		c.SetArgs(args)
+		_, err := c.Execute()
+		if exp := len(args) == 2 && args[1] == "quick"; (err != nil) != exp {
+			t.Errorf("%d: Expected an error only for an invalid mode, got %v", ti, err)
+		}
+	}
+
+	type bad struct {
+		Src int `arg:"rest"`
+	}
+	if _, err := NewTyped[bad]("test", ""); err == nil {
+		t.Error(`arg:"rest" on an int should be rejected`)
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	type s struct {
+		Log struct {
+			Level string `enum:"debug,info"`
+		}
+	}
+	tests := []struct {
+		args     []string
+		expected []string
+		err      string
+	}{
+		{[]string{"--log-level", "debug"}, []string{"before", "merge debug", "persistent", "validate debug", "prerun", "run"}, ""},
+		{[]string{"--log-level", "loud"}, []string{"before", "merge loud", "persistent", "error"}, "wrapped"},
+	}
+	for ti, test := range tests {
+		var calls []string
+		cobCmd := &cobra.Command{
+			Use:           "test",
+			SilenceUsage:  true,
+			SilenceErrors: true,
+			PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+				calls = append(calls, "persistent")
+				return nil
+			},
+			PreRunE: func(cmd *cobra.Command, args []string) error {
+				calls = append(calls, "prerun")
+				return nil
+			},
+			Run: func(cmd *cobra.Command, args []string) { calls = append(calls, "run") },
+		}
+		cfg := s{}
+		c := NewWithCommand(cobCmd, &cfg)
+		c.SetHooks(Hooks{
+			BeforeLoad: func(c *Config) error {
+				calls = append(calls, "before")
+				c.SetEnv(map[string]string{})
+				return nil
+			},
+			AfterMerge: func(c *Config) error {
+				calls = append(calls, "merge "+cfg.Log.Level)
+				return nil
+			},
+			AfterValidate: func(c *Config) error {
+				calls = append(calls, "validate "+cfg.Log.Level)
+				return nil
+			},
+			OnError: func(c *Config, err error) error {
+				calls = append(calls, "error")
+				return fmt.Errorf("wrapped")
+			},
+		})
+		c.SetArgs(test.args)
+		_, err := c.Execute()
+		if fmt.Sprint(err) != test.err && !(err == nil && test.err == "") {
+			t.Errorf("%d: Expected error %q, got %v", ti, test.err, err)
+		}
+		if !reflect.DeepEqual(calls, test.expected) {
+			t.Errorf("%d: Expected calls %v, got %v", ti, test.expected, calls)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	type global struct {
+		Addr string
+	}
+	type serve struct {
+		Port int `default:"80"`
+	}
+	type key struct{}
+	var got *serve
+	var addr string
+	var value interface{}
+	cobCmd := &cobra.Command{
+		Use:           "test",
+		Run:           func(cmd *cobra.Command, args []string) {},
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	c := NewWithCommand(cobCmd, &global{})
+	c.AddCommand(&cobra.Command{
+		Use: "serve",
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := CommandContext(cmd)
+			got, _ = FromContext[serve](ctx)
+			if g, ok := FromContext[global](ctx); ok {
+				addr = g.Addr
+			}
+			value = ctx.Value(key{})
+		},
+	}, &serve{})
+	c.SetEnv(map[string]string{"TEST_ADDR": "env"})
+	c.SetArgs([]string{"serve", "--port", "81"})
+	if _, err := c.ExecuteContext(context.WithValue(context.Background(), key{}, "parent")); err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.Port != 81 || addr != "env" || value != "parent" {
+		t.Errorf("Unexpected context values %+v, %q, %v", got, addr, value)
+	}
+	if _, ok := FromContext[serve](CommandContext(cobCmd)); ok {
+		t.Error("The context should be gone once Execute returns")
+	}
+}
+
+func TestNameTags(t *testing.T) {
+	type s struct {
+		Addr string `flag:"listen" short:"l" env:"LISTEN_ADDR" key:"listen_address"`
+		DB   struct {
+			URL  string `env:"DATABASE_URL,noprefix"`
+			Pool int    `flag:"connections" key:"max_conns"`
+		} `key:"database"`
+	}
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/test.yaml", []byte("listen_address: file\ndatabase:\n  max_conns: 5\n  url: file-db\n"), 0644)
+	tests := []struct {
+		args     []string
+		env      map[string]string
+		expected s
+		err      string
+	}{
+		{[]string{"--config", "/test.yaml"}, nil, s{Addr: "file"}, ""},
+		{[]string{"-l", "flag", "--db-connections", "7"}, nil, s{Addr: "flag"}, ""},
+		{[]string{}, map[string]string{"TEST_LISTEN_ADDR": "env", "DATABASE_URL": "env-db", "TEST_DB_POOL": "9"}, s{Addr: "env"}, ""},
+		{[]string{}, map[string]string{"TEST_ADDR": "env"}, s{}, "unknown env var TEST_ADDR"},
+	}
+	tests[0].expected.DB.URL, tests[0].expected.DB.Pool = "file-db", 5
+	tests[1].expected.DB.Pool = 7
+	tests[2].expected.DB.URL, tests[2].expected.DB.Pool = "env-db", 9
+	for ti, test := range tests {
+		cobCmd := &cobra.Command{
+			Use:           "test",
+			Run:           func(cmd *cobra.Command, args []string) {},
+			SilenceUsage:  true,
+			SilenceErrors: true,
+		}
+		cfg := s{}
+		c := NewWithCommand(cobCmd, &cfg)
+		c.Strict()
+		c.SetFs(fs)
+		c.SetEnv(test.env)
+		c.SetArgs(test.args)
+		_, err := c.Execute()
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%d: Expected error %q, got %v", ti, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%d: %v", ti, err)
+		}
+		if cfg != test.expected {
+			t.Errorf("%d: Expected %+v, got %+v", ti, test.expected, cfg)
+		}
+	}
+
+	var buf bytes.Buffer
+	c := NewWithCommand(&cobra.Command{Use: "test"}, &s{})
+	c.WriteMarkdown(&buf)
+	for _, exp := range []string{
+		"| `-l, --listen` | `TEST_LISTEN_ADDR` | `listen_address` |",
+		"| `--db-url` | `DATABASE_URL` | `database.url` |",
+		"| `--db-connections` | `TEST_DB_POOL` | `database.max_conns` |",
+	} {
+		if !strings.Contains(buf.String(), exp) {
+			t.Errorf("Markdown should contain %q:\n%s", exp, buf.String())
+		}
+	}
+
+	if _, err := NewTyped[struct {
+		Level string `short:"ll"`
+	}]("test", ""); err == nil || err.Error() != `invalid short tag "ll" @ Level, expected one ASCII character` {
+		t.Errorf("Expected short:\"ll\" to be rejected, got %v", err)
+	}
+	for _, short := range []string{"é", ""} {
+		typ := reflect.StructOf([]reflect.StructField{{Name: "Level", Type: reflect.TypeOf(""), Tag: reflect.StructTag(`short:"` + short + `"`)}})
+		if err := checkShape(reflect.New(typ).Interface()); err == nil || !strings.Contains(err.Error(), "invalid short tag") {
+			t.Errorf("Expected short %q to be rejected, got %v", short, err)
+		}
+	}
+}
+
+func TestNaming(t *testing.T) {
+	type s struct {
+		Log struct {
+			Level   string
+			MaxSize int
+		}
+	}
+	snake := func(path []string) string {
+		parts := make([]string, len(path))
+		for i, p := range path {
+			parts[i] = underscore(p)
+		}
+		return strings.Join(parts, ".")
+	}
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/test.yaml", []byte("log:\n  level: file\n  max_size: 3\n"), 0644)
+	tests := []struct {
+		opts     []Option
+		args     []string
+		env      map[string]string
+		level    string
+		maxSize  int
+		expected []string
+	}{
+		{nil, []string{"--log-level", "flag"}, map[string]string{"TEST_LOG_MAX_SIZE": "2"}, "flag", 2,
+			[]string{"| `--log-level` | `TEST_LOG_LEVEL` | `log.level` |", "| `--log-max-size` | `TEST_LOG_MAX_SIZE` | `log.maxsize` |"}},
+		{[]Option{WithEnvPrefix("app"), WithFlagSeparator('.'), WithEnvSeparator("__"), WithKeyNamer(snake)},
+			[]string{"--log.level", "flag"}, map[string]string{"APP__LOG__MAX_SIZE": "2"}, "flag", 2,
+			[]string{"| `--log.level` | `APP__LOG__LEVEL` | `log.level` |", "| `--log.max-size` | `APP__LOG__MAX_SIZE` | `log.max_size` |"}},
+		{[]Option{WithNoEnvPrefix(), WithKeyNamer(snake)}, []string{"--config", "/test.yaml"}, map[string]string{"LOG_LEVEL": "env", "HOME": "/"}, "env", 3,
+			[]string{"| `--log-level` | `LOG_LEVEL` | `log.level` |", "| `--log-max-size` | `LOG_MAX_SIZE` | `log.max_size` |"}},
+	}
+	for ti, test := range tests {
+		cfg := s{}
+		c := New("test", "", &cfg, test.opts...)
+		c.Strict()
+		c.SetFs(fs)
+		c.SetEnv(test.env)
+		c.SetArgs(test.args)
+		if _, err := c.Execute(); err != nil {
+			t.Fatalf("%d: %v", ti, err)
+		}
+		if cfg.Log.Level != test.level || cfg.Log.MaxSize != test.maxSize {
+			t.Errorf("%d: Expected %s/%d, got %+v", ti, test.level, test.maxSize, cfg)
+		}
+		var buf bytes.Buffer
+		c.WriteMarkdown(&buf)
+		for _, exp := range test.expected {
+			if !strings.Contains(buf.String(), exp) {
+				t.Errorf("%d: Expected %q in:\n%s", ti, exp, buf.String())
+			}
+		}
+	}
+}
+
+func TestAcronyms(t *testing.T) {
+	acronyms := []string{"OAuth", "ID", "IPv4"}
+	cases := map[string]string{
+		"OAuthToken":  "oauth-token",
+		"OAuth2Token": "oauth2-token",
+		"MyOAuth":     "my-oauth",
+		"OAuthURL":    "oauth-url",
+		"IDsByName":   "ids-by-name",
+		"UserIDs":     "user-ids",
+		"Identity":    "identity",
+		"PAID":        "paid",
+		"IPv4Addr":    "ipv4-addr",
+		"IPv6Addr":    "i-pv6-addr",
+		"OAuthy":      "o-authy",
+	}
+	for test, exp := range cases {
+		if res := splitCamel(test, '-', acronyms...); res != exp {
+			t.Errorf("Splitting %s should have given %s, got %s", test, exp, res)
+		}
+	}
+
+	type s struct {
+		IPv4Addr string
+		OAuth    struct{ ClientID string }
+	}
+	var with, without bytes.Buffer
+	New("test", "", &s{}, WithAcronyms(acronyms...)).WriteMarkdown(&with)
+	New("test", "", &s{}).WriteMarkdown(&without)
+	for buf, expected := range map[*bytes.Buffer][]string{
+		&with: {
+			"| `--ipv4-addr` | `TEST_IPV4_ADDR` | `ipv4addr` |",
+			"| `--oauth-client-id` | `TEST_OAUTH_CLIENT_ID` | `oauth.clientid` |",
+		},
+		&without: {
+			"| `--i-pv4-addr` | `TEST_I_PV4_ADDR` | `ipv4addr` |",
+			"| `--o-auth-client-id` | `TEST_O_AUTH_CLIENT_ID` | `oauth.clientid` |",
+		},
+	} {
+		for _, exp := range expected {
+			if !strings.Contains(buf.String(), exp) {
+				t.Errorf("Expected %q in:\n%s", exp, buf.String())
+			}
+		}
+	}
+}
+
+// checkNames checks that the flag, env var and snake_case file key derived
+// from a Go identifier agree with each other and keep every letter of it.
+// Upper and lower case don't round-trip (ϕ upper cases to Φ, which lower
+// cases to φ), so the env var is compared by case folding.
+func checkNames(t *testing.T, name string) {
+	for _, r := range name {
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return
+		}
+	}
+	if !utf8.ValidString(name) {
+		return
+	}
+	acronyms := []string{"OAuth", "ID", "IPv4"}
+	snake := func(path []string) string { return underscore(strings.Join(path, "_"), acronyms...) }
+	c := New("test", "", &struct{}{}, WithKeyNamer(snake), WithAcronyms(acronyms...))
+	flag, env, key := c.flagFor(nil, name), c.envFor(nil, name), c.keyFor(nil, name, nil)
+	if key != strings.Replace(flag, "-", "_", -1) || !strings.EqualFold(key, env) {
+		t.Errorf("%q: flag %q, env %q and key %q disagree", name, flag, env, key)
+	}
+	if strings.HasPrefix(flag, "-") || strings.HasSuffix(flag, "-") || strings.Contains(flag, "--") {
+		t.Errorf("%q: empty word in %q", name, flag)
+	}
+	if got, exp := strings.Replace(flag, "-", "", -1), strings.ToLower(strings.Replace(name, "_", "", -1)); got != exp {
+		t.Errorf("%q: %q lost letters, expected %q", name, flag, exp)
+	}
+	// Some capitals have no lower case (ϓ), so only underscores, which always
+	// separate, are sure to split the same way again.
+	if again := underscore(key); again != key {
+		t.Errorf("%q: %q splits again into %q", name, key, again)
+	}
+}
+
+func TestNameProperties(t *testing.T) {
+	alphabet := []rune("aBcXyZ09_ÄöΣσ名ǅϕOAuthIDPv4")
+	f := func(idx []uint8) bool {
+		rs := make([]rune, len(idx))
+		for i, n := range idx {
+			rs[i] = alphabet[int(n)%len(alphabet)]
+		}
+		checkNames(t, string(rs))
+		return !t.Failed()
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func FuzzNames(f *testing.F) {
+	for _, seed := range []string{"TestURLStuff", "HTTP2Port", "S3Bucket", "OAuthToken", "OAuth2Token", "UserIDs", "ÜberName", "Max_Size", "aB"} {
+		f.Add(seed)
+	}
+	f.Fuzz(checkNames)
+}
+
+func TestCollisions(t *testing.T) {
+	type sub struct{ String string }
+	tests := []struct {
+		cfg      interface{}
+		expected []string
+	}{
+		{&struct {
+			SubString string
+			Sub       sub
+		}{}, []string{
+			"name collision: --sub-string is used by SubString and Sub.String",
+			"name collision: TEST_SUB_STRING is used by SubString and Sub.String",
+		}},
+		{&struct {
+			Config string
+			Help   bool
+		}{}, []string{
+			"--config is used by built-in --config and Config",
+			"TEST_CONFIG is used by built-in --config and Config",
+			"--help is used by built-in --help and Help",
+		}},
+		{&struct {
+			Host    string `short:"h"`
+			Verbose bool   `short:"v"`
+			Version bool   `short:"v"`
+		}{}, []string{"-h is used by built-in --help and Host", "-v is used by Verbose and Version"}},
+		{&struct {
+			Addr   string `env:"LISTEN"`
+			Listen string
+			Port   int `alias:"Addr"`
+		}{}, []string{"TEST_LISTEN is used by Addr and Listen", "--addr is used by Addr and Port"}},
+		{&struct{ Sub sub }{}, nil},
+	}
+	for ti, test := range tests {
+		c := New("test", "", test.cfg)
+		c.SetEnv(map[string]string{})
+		c.SetArgs([]string{})
+		_, err := c.Execute()
+		var ce *CollisionError
+		if len(test.expected) == 0 {
+			if err != nil {
+				t.Errorf("%d: %v", ti, err)
+			}
+			continue
+		}
+		if !errors.As(err, &ce) {
+			t.Fatalf("%d: Expected a CollisionError, got %v", ti, err)
+		}
+		for _, exp := range test.expected {
+			if !strings.Contains(err.Error(), exp) {
+				t.Errorf("%d: Expected %q in %q", ti, exp, err)
+			}
+		}
+	}
+
+	root := New("test", "", &struct{ Port int }{})
+	root.AddCommand(&cobra.Command{Use: "serve", Run: func(*cobra.Command, []string) {}}, &struct{ Port int }{})
+	root.SetEnv(map[string]string{})
+	root.SetArgs([]string{"serve"})
+	if _, err := root.Execute(); err == nil || !strings.Contains(err.Error(), "--port is used by Port and Port (serve)") {
+		t.Errorf("Expected --port to collide with the subcommand's, got %v", err)
+	}
+
+	if _, err := NewTyped[struct{ Config string }]("test", ""); err == nil || !strings.Contains(err.Error(), "--config is used by built-in --config and Config") {
+		t.Errorf("Expected NewTyped to report the collision, got %v", err)
+	}
+	if _, err := BindFlagSet(pflag.NewFlagSet("tool", pflag.ContinueOnError), &struct {
+		SubString string
+		Sub       sub
+	}{}); err == nil || !strings.Contains(err.Error(), "--sub-string is used by SubString and Sub.String") {
+		t.Errorf("Expected BindFlagSet to report the collision, got %v", err)
+	}
+}
+
+func TestHiddenOptions(t *testing.T) {
+	type s struct {
+		Addr  string `desc:"listen address"`
+		Debug bool   `hidden:"true" desc:"debug knob"`
+		Trace string `advanced:"true" desc:"trace filter"`
+	}
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/test.yaml", []byte("debug: true\n"), 0644)
+	tests := []struct {
+		args     []string
+		env      map[string]string
+		expected s
+		err      error
+		shown    []string
+		notShown []string
+	}{
+		{[]string{"--debug", "--trace", "db"}, nil, s{Debug: true, Trace: "db"}, nil, nil, nil},
+		{[]string{}, map[string]string{"TEST_DEBUG": "true"}, s{Debug: true}, nil, nil, nil},
+		{[]string{"--config", "/test.yaml"}, nil, s{Debug: true}, nil, nil, nil},
+		{[]string{"--help"}, nil, s{}, ErrHelpRequested,
+			[]string{"--addr", `Use "test --help-all" to include advanced options.`},
+			[]string{"--debug", "--trace"}},
+		{[]string{"--help-all"}, nil, s{}, ErrHelpRequested,
+			[]string{"--addr", "--trace", "trace filter [$TEST_TRACE]"},
+			[]string{"--debug", "to include advanced options"}},
+		{[]string{"--help-env"}, nil, s{}, nil, []string{"TEST_ADDR"}, []string{"TEST_DEBUG", "TEST_TRACE"}},
+		{[]string{"--help-env", "--help-all"}, nil, s{}, nil, []string{"TEST_ADDR", "TEST_TRACE"}, []string{"TEST_DEBUG"}},
+	}
+	for ti, test := range tests {
+		cfg := s{}
+		c := New("test", "", &cfg)
+		c.SetFs(fs)
+		c.SetEnv(test.env)
+		c.SetArgs(test.args)
+		var out bytes.Buffer
+		c.SetOutput(&out, &out)
+		if _, err := c.Execute(); err != test.err {
+			t.Fatalf("%d: Expected %v, got %v", ti, test.err, err)
+		}
+		if test.shown == nil && cfg != test.expected {
+			t.Errorf("%d: Expected %+v, got %+v", ti, test.expected, cfg)
+		}
+		for _, exp := range test.shown {
+			if !strings.Contains(out.String(), exp) {
+				t.Errorf("%d: Expected %q in:\n%s", ti, exp, out.String())
+			}
+		}
+		for _, exp := range test.notShown {
+			if strings.Contains(out.String(), exp) {
+				t.Errorf("%d: Didn't expect %q in:\n%s", ti, exp, out.String())
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	New("test", "", &s{}).WriteMarkdown(&buf)
+	if strings.Contains(buf.String(), "--debug") || !strings.Contains(buf.String(), "| *Advanced.* trace filter |") {
+		t.Errorf("Expected --debug left out and --trace marked advanced in:\n%s", buf.String())
+	}
+}
+
+func TestSources(t *testing.T) {
+	type s struct {
+		Password string `secret:"true" noflag:"true"`
+		Token    string `secret:"true" default:"abc"`
+		Pin      int    `secret:"true" max:"10"`
+		Key      string `secret:"true" enum:"a,b"`
+		Mode     string `sources:"flag"`
+	}
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/pw.yaml", []byte("password: file-pw\n"), 0644)
+	afero.WriteFile(fs, "/mode.yaml", []byte("mode: file\n"), 0644)
+	tests := []struct {
+		args     []string
+		env      map[string]string
+		expected s
+		err      string
+		warning  string
+	}{
+		{[]string{}, map[string]string{"TEST_PASSWORD": "env-pw"}, s{Password: "env-pw", Token: "abc"}, "", ""},
+		{[]string{"--config", "/pw.yaml"}, nil, s{Password: "file-pw", Token: "abc"}, "", ""},
+		{[]string{"--password", "flag-pw"}, nil, s{}, "source not allowed: set $TEST_PASSWORD or file key password instead of --password", ""},
+		{[]string{"--token", "flag-token"}, nil, s{Token: "flag-token"}, "", `msg="secret given on the command line" option=--token`},
+		{[]string{}, map[string]string{"TEST_PIN": "hunter2"}, s{}, `invalid argument "[REDACTED]"`, ""},
+		{[]string{}, map[string]string{"TEST_PIN": "1234"}, s{}, `--pin ($TEST_PIN) is [REDACTED], expected at most 10`, ""},
+		{[]string{}, map[string]string{"TEST_KEY": "hunter2"}, s{}, `--key ($TEST_KEY) is [REDACTED], expected one of a, b`, ""},
+		{[]string{"--config", "/mode.yaml"}, nil, s{}, "source not allowed: set --mode instead of file key mode", ""},
+		{[]string{"--mode", "flag"}, map[string]string{"TEST_MODE": "env"}, s{Token: "abc", Mode: "flag"}, "", ""},
+		{[]string{}, map[string]string{"TEST_MODE": "env"}, s{Token: "abc"}, "", ""},
+	}
+	for ti, test := range tests {
+		cfg := s{}
+		c := New("test", "", &cfg)
+		c.SilenceUsage()
+		c.SetFs(fs)
+		c.SetEnv(test.env)
+		c.SetArgs(test.args)
+		var log bytes.Buffer
+		c.SetLogger(slog.New(slog.NewTextHandler(&log, nil)))
+		c.SetOutput(ioutil.Discard, ioutil.Discard)
+		_, err := c.Execute()
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%d: Expected error %q, got %v", ti, test.err, err)
+			}
+			for _, v := range test.env {
+				if err != nil && strings.Contains(err.Error(), v) {
+					t.Errorf("%d: Secret leaked in %q", ti, err)
+				}
+			}
+			if strings.Contains(test.err, "not allowed") && !errors.Is(err, ErrSourceNotAllowed) {
+				t.Errorf("%d: Expected ErrSourceNotAllowed, got %v", ti, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%d: %v", ti, err)
+		}
+		if cfg != test.expected {
+			t.Errorf("%d: Expected %+v, got %+v", ti, test.expected, cfg)
+		}
+		if !strings.Contains(log.String(), test.warning) || test.warning == "" && log.Len() > 0 {
+			t.Errorf("%d: Expected warning %q, got %q", ti, test.warning, log.String())
+		}
+	}
+
+	c := New("test", "", &s{})
+	var help, env, md bytes.Buffer
+	c.SetOutput(&env, &help)
+	c.SetArgs([]string{"--help"})
+	c.Execute()
+	if strings.Contains(help.String(), "--password") || strings.Contains(help.String(), `"abc"`) || !strings.Contains(help.String(), `(default "[REDACTED]")`) {
+		t.Errorf("Expected --password left out and --token's default redacted in:\n%s", help.String())
+	}
+	c.WriteEnvHelp(&env)
+	if !strings.Contains(env.String(), "TEST_PASSWORD") || strings.Contains(env.String(), "TEST_MODE") {
+		t.Errorf("Expected TEST_PASSWORD but not TEST_MODE in:\n%s", env.String())
+	}
+	c.WriteMarkdown(&md)
+	for _, exp := range []string{"|  | `TEST_PASSWORD` | `password` |", "| `--mode` |  |  |"} {
+		if !strings.Contains(md.String(), exp) {
+			t.Errorf("Expected %q in:\n%s", exp, md.String())
+		}
+	}
+
+	var sample, printed bytes.Buffer
+	if err := c.WriteSample(&sample, FormatYAML); err != nil || strings.Contains(sample.String(), "mode:") {
+		t.Errorf("Expected mode left out of the sample, got %v:\n%s", err, sample.String())
+	}
+	afero.WriteFile(fs, "/sample.yaml", sample.Bytes(), 0644)
+	c = New("test", "", &s{})
+	c.SetFs(fs)
+	c.SetEnv(map[string]string{})
+	c.SetArgs([]string{"--config", "/sample.yaml", "--print-config", "env"})
+	c.SetOutput(&printed, ioutil.Discard)
+	if _, err := c.Execute(); err != nil {
+		t.Errorf("Expected the sample to load, got %v", err)
+	}
+	if b, err := c.Schema(); err != nil || strings.Contains(string(b), `"mode"`) {
+		t.Errorf("Expected mode left out of the schema, got %v:\n%s", err, b)
+	}
+	if !strings.Contains(printed.String(), "TEST_TOKEN") || strings.Contains(printed.String(), "TEST_MODE") {
+		t.Errorf("Expected TEST_TOKEN but not TEST_MODE in:\n%s", printed.String())
+	}
+
+	gfs := flag.NewFlagSet("test", flag.ContinueOnError)
+	if _, err := BindGoFlagSet(gfs, &s{}); err != nil || gfs.Lookup("password") != nil || gfs.Lookup("token") == nil {
+		t.Errorf("Expected -token but not -password on the go flag set, got %v", err)
+	}
+
+	if _, err := NewTyped[struct {
+		Password string `sources:"cli"`
+	}]("test", ""); err == nil || !strings.Contains(err.Error(), `invalid sources tag "cli" @ Password`) {
+		t.Errorf("Expected an invalid sources tag error, got %v", err)
+	}
+}
+
+func TestSliceValues(t *testing.T) {
+	type s struct {
+		Tags  []string
+		Hosts []string `default:"a,b"`
+	}
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/test.yaml", []byte("tags: [file1, file2]\n"), 0644)
+	tests := []struct {
+		args     []string
+		env      map[string]string
+		expected s
+	}{
+		{[]string{}, nil, s{Hosts: []string{"a", "b"}}},
+		{[]string{"--tags", "x,y", "--hosts", "h"}, nil, s{Tags: []string{"x", "y"}, Hosts: []string{"h"}}},
+		{[]string{"--tags", "x", "--tags", "y"}, nil, s{Tags: []string{"x", "y"}, Hosts: []string{"a", "b"}}},
+		{[]string{}, map[string]string{"TEST_TAGS": "e1,e2"}, s{Tags: []string{"e1", "e2"}, Hosts: []string{"a", "b"}}},
+		{[]string{"--config", "/test.yaml"}, nil, s{Tags: []string{"file1", "file2"}, Hosts: []string{"a", "b"}}},
+		{[]string{"--config", "/test.yaml", "--tags", "x"}, nil, s{Tags: []string{"x"}, Hosts: []string{"a", "b"}}},
+	}
+	for ti, test := range tests {
+		cfg := s{}
+		c := New("test", "", &cfg)
+		c.SetFs(fs)
+		c.SetEnv(test.env)
+		c.SetArgs(test.args)
+		if _, err := c.Execute(); err != nil {
+			t.Fatalf("%d: %v", ti, err)
+		}
+		if !reflect.DeepEqual(cfg, test.expected) {
+			t.Errorf("%d: Expected %+v, got %+v", ti, test.expected, cfg)
+		}
+	}
+}
+
+func TestWriteMan(t *testing.T) {
+	type s struct {
+		Addr string `desc:"address" required:"true"`
+	}
+	c := New("test", "test desc", &s{})
+	var first, second bytes.Buffer
+	c.WriteMan(&first, time.Time{})
+	c.WriteMan(&second, time.Time{})
+	if !strings.HasPrefix(first.String(), ".TH TEST 1 \"\"\n") || first.String() != second.String() {
+		t.Errorf("Expected an undated, reproducible page, got:\n%s\n%s", first.String(), second.String())
+	}
+	if !strings.Contains(first.String(), "address\n.br\nEnv: TEST_ADDR, file key: addr, required.\n") {
+		t.Errorf("Expected --addr documented in:\n%s", first.String())
+	}
+	c.AddCommand(&cobra.Command{Use: "serve"}, &struct {
+		Dir string `default:"."`
+	}{})
+	var withSub bytes.Buffer
+	c.WriteMan(&withSub, time.Time{})
+	if !strings.Contains(withSub.String(), ".SH COMMANDS\n.TP\n.B test serve\n\n.RS\n.TP\n.BR \\-\\-dir \" \" \\fIstring\\fR\nEnv: TEST_SERVE_DIR, file key: serve.dir, default: \".\".\n.RE\n") {
+		t.Errorf("Expected --dir documented under serve in:\n%s", withSub.String())
+	}
+	var dated bytes.Buffer
+	c.WriteMan(&dated, time.Date(2017, time.March, 1, 0, 0, 0, 0, time.UTC))
+	if !strings.HasPrefix(dated.String(), ".TH TEST 1 \"March 2017\"\n") {
+		t.Errorf("Expected the given date, got:\n%s", dated.String())
+	}
+}
+
+func TestImplicitArgs(t *testing.T) {
+	type s struct{ Port int }
+	defer func(args []string) { os.Args = args }(os.Args)
+	os.Args = []string{"/bin/prog", "--port", "9", "extra"}
+	tests := []struct {
+		setArgs  bool
+		args     []string
+		expected int
+		cmdArgs  []string
+	}{
+		{false, nil, 9, []string{"extra"}}, // the compatibility fallback to os.Args
+		{true, nil, 0, nil},
+		{true, []string{}, 0, nil},
+		{true, []string{"--port", "7"}, 7, nil},
+	}
+	for ti, test := range tests {
+		cfg := s{}
+		c := New("test", "", &cfg)
+		var cmdArgs []string
+		c.Cmd.Run = func(cmd *cobra.Command, args []string) { cmdArgs = args }
+		c.SetEnv(map[string]string{})
+		if test.setArgs {
+			c.SetArgs(test.args)
+		}
+		if _, err := c.Execute(); err != nil {
+			t.Fatalf("%d: %v", ti, err)
+		}
+		if cfg.Port != test.expected {
+			t.Errorf("%d: Expected port %d, got %d", ti, test.expected, cfg.Port)
+		}
+		if changed := c.Cmd.Flags().Lookup("port").Changed; changed != (test.expected != 0) || fmt.Sprint(cmdArgs) != fmt.Sprint(test.cmdArgs) {
+			t.Errorf("%d: Expected cobra to parse %v, got --port changed %v, args %v", ti, test.args, changed, cmdArgs)
+		}
+	}
+
+	cfg := s{}
+	c, err := BindFlagSet(pflag.NewFlagSet("tool", pflag.ContinueOnError), &cfg,
+		WithEnvPrefix("tool"), WithEnv(map[string]string{"PROG_PORT": "1", "TOOL_PORT": "2"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ParseFlagSet([]string{}); err != nil || cfg.Port != 2 {
+		t.Errorf("Expected WithEnvPrefix to replace the executable's name, got %d, %v", cfg.Port, err)
+	}
+}
+
 func TestSplitCamel(t *testing.T) {
 	cases := map[string]string{
 		"Test":         "test",
@@ -580,6 +2360,23 @@ func TestSplitCamel(t *testing.T) {
 		"tEsTiNg":      "t-es-ti-ng",
 		"TeStInG":      "te-st-in-g",
 		"TeStINg":      "te-st-ing",
+		"HTTP2Port":    "http2-port",
+		"S3Bucket":     "s3-bucket",
+		"Port8080":     "port8080",
+		"V2API":        "v2-api",
+		"UserID":       "user-id",
+		"UserIDs":      "user-ids",
+		"IDsByName":    "i-ds-by-name",
+		"Identity":     "identity",
+		"PAID":         "paid",
+		"OAuthToken":   "o-auth-token",
+		"MyOAuth":      "my-o-auth",
+		"TLSConfig":    "tls-config",
+		"Max_Size":     "max-size",
+		"ÜberName":     "über-name",
+		"naïveÉtat":    "naïve-état",
+		"ΣίγμαΤιμή":    "σίγμα-τιμή",
+		"名前Value":      "名前-value",
 	}
 	for test, exp := range cases {
 		res := splitCamel(test, '-')
@@ -0,0 +1,262 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ConstraintError is a violated cross-field rule. Options lists every option
+// involved. Rules are declared with tags that name other fields by their Go
+// path, relative to the declaring struct or from the root:
+//
+//	`requires:"TLSKey,TLSCA"`   if this field is set, so must these be.
+//	`conflicts:"Addr"`          this field and Addr can't both be set.
+//	`group:"auth,oneof"`        at least one field in group auth must be set.
+//	`required_if:"Mode=tls"`    required when Mode is tls.
+//	`enum:"debug,info,error"`   the value (or each item of a list) is one of these.
+//	`min:"1" max:"65535"`       bounds for a number.
+//
+// A field counts as set when any layer other than a default provided it.
+type ConstraintError struct {
+	Rule    string
+	Options []string
+	msg     string
+}
+
+func (e *ConstraintError) Error() string {
+	return e.msg
+}
+
+// group splits a `group:"name[,oneof]"` tag.
+func (f *field) group() (name string, oneOf bool) {
+	parts := strings.Split(f.tag.Get("group"), ",")
+	return parts[0], len(parts) > 1 && parts[1] == "oneof"
+}
+
+// option names f the way a user would set it.
+func (f *field) option() string {
+	if _, ok := f.tag.Lookup("arg"); ok {
+		return f.argSyntax()
+	}
+	return fmt.Sprintf("--%s ($%s)", f.flag, f.env)
+}
+
+func options(fs []*field) []string {
+	opts := make([]string, len(fs))
+	for i, f := range fs {
+		opts[i] = f.option()
+	}
+	return opts
+}
+
+func (c *Config) isSet(f *field) bool {
+	return c.source(f) > SourceDefault
+}
+
+// lookupField resolves a field reference from a constraint tag on f, first
+// among f's siblings and then from the root struct.
+func (c *Config) lookupField(f *field, ref string) *field {
+	sibling := strings.Join(append(append([]string{}, f.path[:len(f.path)-1]...), ref), ".")
+	for _, p := range []string{sibling, ref} {
+		for _, o := range c.fields {
+			if o.Path() == p {
+				return o
+			}
+		}
+	}
+	return nil
+}
+
+// refs resolves a comma separated tag, reporting unknown references as errors.
+func (c *Config) refs(f *field, tag string, errs *Errors) []*field {
+	var fs []*field
+	for _, ref := range strings.Split(f.tag.Get(tag), ",") {
+		if ref = strings.TrimSpace(ref); ref == "" {
+			continue
+		}
+		o := c.lookupField(f, ref)
+		if o == nil {
+			*errs = append(*errs, c.fieldError(f, fmt.Errorf("%s references unknown field %s", tag, ref)))
+			continue
+		}
+		fs = append(fs, o)
+	}
+	return fs
+}
+
+// checkConstraints evaluates every cross-field rule against all sources.
+func (c *Config) checkConstraints() Errors {
+	var errs Errors
+	conflicts := map[[2]*field]bool{}
+	groups := map[string][]*field{}
+	var groupNames []string
+	for _, f := range c.fields {
+		set := c.isSet(f)
+		for _, o := range c.refs(f, "requires", &errs) {
+			if set && !c.isSet(o) {
+				errs = append(errs, c.fieldError(f, &ConstraintError{
+					Rule:    "requires",
+					Options: options([]*field{f, o}),
+					msg:     fmt.Sprintf("%s requires %s", f.option(), o.option()),
+				}))
+			}
+		}
+		for _, o := range c.refs(f, "conflicts", &errs) {
+			if set && c.isSet(o) && !conflicts[[2]*field{o, f}] {
+				conflicts[[2]*field{f, o}] = true
+				errs = append(errs, c.fieldError(f, &ConstraintError{
+					Rule:    "conflicts",
+					Options: options([]*field{f, o}),
+					msg:     fmt.Sprintf("%s conflicts with %s", f.option(), o.option()),
+				}))
+			}
+		}
+		if cond := f.tag.Get("required_if"); cond != "" && !set {
+			kv := strings.SplitN(cond, "=", 2)
+			o := c.lookupField(f, kv[0])
+			if o == nil || len(kv) != 2 {
+				errs = append(errs, c.fieldError(f, fmt.Errorf("invalid required_if %q", cond)))
+			} else if fmt.Sprintf("%v", o.value) == kv[1] {
+				errs = append(errs, c.fieldError(f, &ConstraintError{
+					Rule:    "required_if",
+					Options: options([]*field{f, o}),
+					msg:     fmt.Sprintf("%s is required when %s is %s", f.option(), o.option(), kv[1]),
+				}))
+			}
+		}
+		if set {
+			errs = append(errs, c.checkValue(f)...)
+		}
+		if name, oneOf := f.group(); name != "" && oneOf {
+			if _, ok := groups[name]; !ok {
+				groupNames = append(groupNames, name)
+			}
+			groups[name] = append(groups[name], f)
+		}
+	}
+	sort.Strings(groupNames)
+	for _, name := range groupNames {
+		members := groups[name]
+		anySet := false
+		for _, m := range members {
+			anySet = anySet || c.isSet(m)
+		}
+		if !anySet {
+			errs = append(errs, c.fieldError(members[0], &ConstraintError{
+				Rule:    "group",
+				Options: options(members),
+				msg:     fmt.Sprintf("at least one of %s must be set (%s)", strings.Join(options(members), ", "), name),
+			}))
+		}
+	}
+	return errs
+}
+
+// checkValue checks f's value against its enum, min and max tags.
+func (c *Config) checkValue(f *field) Errors {
+	var errs Errors
+	if enum := f.enum(); len(enum) > 0 {
+		if v, ok := outside(f.value, enum); ok {
+			errs = append(errs, c.fieldError(f, &ConstraintError{
+				Rule:    "enum",
+				Options: options([]*field{f}),
+				msg:     fmt.Sprintf("%s is %s, expected one of %s", f.option(), f.shown("%q", v), strings.Join(enum, ", ")),
+			}))
+		}
+	}
+	n, numeric := number(f.value)
+	if min, ok := f.bound("min"); ok && numeric && n < min {
+		errs = append(errs, c.fieldError(f, &ConstraintError{
+			Rule:    "min",
+			Options: options([]*field{f}),
+			msg:     fmt.Sprintf("%s is %s, expected at least %v", f.option(), f.shown("%v", f.value), min),
+		}))
+	}
+	if max, ok := f.bound("max"); ok && numeric && n > max {
+		errs = append(errs, c.fieldError(f, &ConstraintError{
+			Rule:    "max",
+			Options: options([]*field{f}),
+			msg:     fmt.Sprintf("%s is %s, expected at most %v", f.option(), f.shown("%v", f.value), max),
+		}))
+	}
+	return errs
+}
+
+// shown formats v for an error message, redacted if f is a secret.
+func (f *field) shown(format string, v interface{}) string {
+	if f.secret() {
+		return redacted
+	}
+	return fmt.Sprintf(format, v)
+}
+
+// annotateConstraints adds the cross-field rules to each flag's help text.
+func (c *Config) annotateConstraints() {
+	groups := map[string][]string{}
+	for _, f := range c.fields {
+		if name, oneOf := f.group(); name != "" && oneOf {
+			groups[name] = append(groups[name], "--"+f.flag)
+		}
+	}
+	for _, f := range c.fields {
+		lup := c.flags().Lookup(f.flag)
+		if lup == nil {
+			continue
+		}
+		var notes []string
+		var errs Errors
+		if fs := c.refs(f, "requires", &errs); len(fs) > 0 {
+			notes = append(notes, "requires "+flagList(fs))
+		}
+		if fs := c.refs(f, "conflicts", &errs); len(fs) > 0 {
+			notes = append(notes, "conflicts with "+flagList(fs))
+		}
+		if cond := f.tag.Get("required_if"); cond != "" {
+			notes = append(notes, "required if "+cond)
+		}
+		if name, oneOf := f.group(); name != "" && oneOf {
+			notes = append(notes, fmt.Sprintf("%s: one of %s", name, strings.Join(groups[name], ", ")))
+		}
+		if len(notes) > 0 {
+			lup.Usage = strings.TrimSpace(lup.Usage + " (" + strings.Join(notes, "; ") + ")")
+		}
+	}
+}
+
+func flagList(fs []*field) string {
+	names := make([]string, len(fs))
+	for i, f := range fs {
+		names[i] = "--" + f.flag
+	}
+	return strings.Join(names, ", ")
+}
+
+// outside returns the first value in v (a scalar or a list) not in allowed.
+func outside(v reflect.Value, allowed []string) (string, bool) {
+	items := []reflect.Value{v}
+	if v.Kind() == reflect.Slice {
+		items = items[:0]
+		for i := 0; i < v.Len(); i++ {
+			items = append(items, v.Index(i))
+		}
+	}
+	for _, item := range items {
+		if s := fmt.Sprintf("%v", item); !contains(allowed, s) {
+			return s, true
+		}
+	}
+	return "", false
+}
+
+// number is the value of a numeric field as a float64.
+func number(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	"github.com/spf13/cobra"
+)
+
+// ctxKey keys a config snapshot in a context by its pointer type.
+type ctxKey struct {
+	t reflect.Type
+}
+
+// running holds the context of each root command while ExecuteContext runs
+// it. The vendored cobra predates cmd.Context(), see CommandContext.
+var running sync.Map
+
+// Context returns parent with a snapshot (a shallow copy) of the root config
+// and of the subcommand configs of the command being run, for FromContext.
+func (c *Config) Context(parent context.Context) context.Context {
+	ctx := parent
+	for _, cfg := range append([]*Config{c}, c.active()...) {
+		v := reflect.ValueOf(cfg.cfg)
+		snapshot := reflect.New(v.Elem().Type())
+		snapshot.Elem().Set(v.Elem())
+		ctx = context.WithValue(ctx, ctxKey{v.Type()}, snapshot.Interface())
+	}
+	return ctx
+}
+
+// FromContext returns the config snapshot of type T stored by Execute.
+func FromContext[T any](ctx context.Context) (*T, bool) {
+	cfg, ok := ctx.Value(ctxKey{reflect.TypeOf((*T)(nil))}).(*T)
+	return cfg, ok
+}
+
+// CommandContext returns the context the command tree of cmd is being run
+// with by ExecuteContext (or Execute), holding the parsed configs. Outside a
+// run it returns context.Background().
+func CommandContext(cmd *cobra.Command) context.Context {
+	if ctx, ok := running.Load(cmd.Root()); ok {
+		return ctx.(context.Context)
+	}
+	return context.Background()
+}
+
+// ExecuteContext is Execute with a parent context for the command, see
+// CommandContext and FromContext.
+func (c *Config) ExecuteContext(ctx context.Context) (interface{}, error) {
+	cfg, err := c.execute(ctx)
+	return cfg, c.onError(err)
+}
+
+// ExecuteContext is Execute with a parent context for the command.
+func (t *Typed[T]) ExecuteContext(ctx context.Context) (*T, error) {
+	_, err := t.Config.ExecuteContext(ctx)
+	return t.cfg, err
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/spf13/pflag"
+)
+
+// SetLogger sets where warnings such as deprecations go. By default they are
+// written as text to the command's stderr.
+func (c *Config) SetLogger(l *slog.Logger) {
+	c.logger = l
+}
+
+func (c *Config) log() *slog.Logger {
+	if c.parent != nil {
+		return c.root().log()
+	}
+	if c.logger == nil {
+		c.logger = slog.New(slog.NewTextHandler(c.Cmd.OutOrStderr(), nil))
+	}
+	return c.logger
+}
+
+// aliases are the old Go field names in sf's alias tag. An old flag name
+// (old-name) stands for the Go name it was derived from (OldName), so its env
+// var and file key are derived the same way.
+func aliases(sf reflect.StructField) []string {
+	var names []string
+	for _, a := range strings.Split(sf.Tag.Get("alias"), ",") {
+		if a = strings.TrimSpace(a); a == "" {
+			continue
+		}
+		if strings.Contains(a, "-") {
+			a = goName(a)
+		}
+		names = append(names, a)
+	}
+	return names
+}
+
+// goName turns a flag name into a Go field name, e.g. max-size to MaxSize.
+func goName(flag string) string {
+	var name string
+	for _, word := range strings.Split(flag, "-") {
+		r, size := utf8.DecodeRuneInString(word)
+		name += string(unicode.ToUpper(r)) + word[size:]
+	}
+	return name
+}
+
+var (
+	goIdent  = regexp.MustCompile(`^[\pL_][\pL\pN_]*$`)
+	flagWord = regexp.MustCompile(`^\p{Ll}[\p{Ll}\pN]*(-[\p{Ll}\pN]+)*$`)
+)
+
+// checkAliasTag rejects aliases that are neither a Go field name nor a flag
+// name, since no flag, env var or file key could match them.
+func checkAliasTag(sf reflect.StructField, path string) error {
+	for _, a := range strings.Split(sf.Tag.Get("alias"), ",") {
+		if a = strings.TrimSpace(a); a != "" && !goIdent.MatchString(a) && !flagWord.MatchString(a) {
+			return fmt.Errorf("invalid alias %q @ %s, expected a Go field name (OldName) or flag name (old-name)", a, path)
+		}
+	}
+	return nil
+}
+
+// bindDeprecated registers hidden flags for f's aliases and hides deprecated
+// fields. Alias env vars are read by applyEnv.
+func (c *Config) bindDeprecated(f *field) {
+	flags := c.flags()
+	lup := flags.Lookup(f.flag)
+	if lup == nil {
+		return
+	}
+	if len(f.aliases) == 0 {
+		if _, ok := f.tag.Lookup("deprecated"); ok {
+			lup.Hidden = true
+		}
+		return
+	}
+	for _, a := range f.aliases {
+		if flags.Lookup(a.flag) == nil {
+			// The alias shares the field's flag value, so --old-name sets it.
+			flags.AddFlag(&pflag.Flag{
+				Name:     a.flag,
+				Usage:    lup.Usage,
+				Value:    lup.Value,
+				DefValue: lup.DefValue,
+				Hidden:   true,
+			})
+		}
+	}
+}
+
+// applyDeprecated carries values given under an alias over to the field and
+// warns about every deprecated name in use.
+func (c *Config) applyDeprecated() error {
+	flags := c.flags()
+	for _, f := range c.fields {
+		msg, deprecated := f.tag.Lookup("deprecated")
+		if len(f.aliases) == 0 {
+			if deprecated {
+				c.warnUsed(f, f, msg)
+			}
+			continue
+		}
+		for _, a := range f.aliases {
+			if af := flags.Lookup(a.flag); af != nil && af.Changed {
+				flags.Lookup(f.flag).Changed = true
+			}
+			if c.file != nil && c.file.Get(a.key) != nil && c.file.Get(f.key) == nil {
+				if err := c.file.UnmarshalKey(a.key, f.value.Addr().Interface()); err != nil {
+					return c.fieldError(f, err)
+				}
+			}
+			c.warnUsed(a, f, msg)
+		}
+	}
+	return nil
+}
+
+// warnUsed logs a deprecation for every source that used one of old's names.
+// f is the field that replaces it.
+func (c *Config) warnUsed(old, f *field, msg string) {
+	used := map[Source]string{}
+	if lup := c.flags().Lookup(old.flag); lup != nil && lup.Changed {
+		used[SourceFlag] = "--" + old.flag
+	}
+	if _, ok := c.getenv(old.env); ok {
+		used[SourceEnv] = old.env
+	}
+	if c.file != nil && c.file.Get(old.key) != nil {
+		used[SourceFile] = old.key
+	}
+	for _, src := range []Source{SourceFlag, SourceEnv, SourceFile} {
+		name, ok := used[src]
+		if !ok {
+			continue
+		}
+		m := msg
+		if m == "" {
+			m = "use " + map[Source]string{SourceFlag: "--" + f.flag, SourceEnv: f.env, SourceFile: f.key}[src]
+		}
+		c.log().Warn("deprecated option", "option", name, "field", f.Path(), "source", src.String(), "message", m)
+	}
+}
@@ -0,0 +1,297 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// docRow is one option in the reference documentation.
+type docRow struct {
+	Flag        string
+	Env         string
+	Key         string
+	Type        string
+	Default     string
+	Required    bool
+	Description string
+	Deprecated  string // empty unless the option itself is deprecated
+	Advanced    bool
+	Aliases     []string
+}
+
+func (c *Config) docRows() []docRow {
+	if c.fields == nil {
+		c.setup()
+	}
+	var rows []docRow
+	for _, f := range c.fields {
+		if f.hidden() {
+			continue
+		}
+		row := docRow{
+			Flag:        "--" + f.flag,
+			Env:         f.env,
+			Key:         f.key,
+			Type:        f.value.Type().String(),
+			Required:    f.required(),
+			Description: f.desc(),
+			Advanced:    f.advanced(),
+		}
+		if short := f.tag.Get("short"); short != "" {
+			row.Flag = "-" + short + ", " + row.Flag
+		}
+		if !f.allows(SourceFlag) {
+			row.Flag = ""
+		}
+		if !f.allows(SourceEnv) {
+			row.Env = ""
+		}
+		if !f.allows(SourceFile) {
+			row.Key = ""
+		}
+		if f.secret() {
+			row.Default = "(secret)"
+		} else if _, ok := c.preset[f.Path()]; ok || f.defaultTag() != "" {
+			row.Default = literal(c.defaultValue(f))
+		}
+		msg, deprecated := f.tag.Lookup("deprecated")
+		if deprecated && len(f.aliases) == 0 {
+			row.Deprecated = msg
+			if msg == "" {
+				row.Deprecated = "deprecated"
+			}
+		}
+		for _, a := range f.aliases {
+			row.Aliases = append(row.Aliases, "--"+a.flag, a.env, a.key)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// otherFlags are the command's flags that don't belong to a field of c or of
+// its parents.
+func (c *Config) otherFlags(flags *pflag.FlagSet) []*pflag.Flag {
+	own := c.own()
+	var others []*pflag.Flag
+	flags.VisitAll(func(fl *pflag.Flag) {
+		if !own[fl.Name] && !fl.Hidden {
+			others = append(others, fl)
+		}
+	})
+	return others
+}
+
+// WriteMarkdown writes a reference of every option (flag, env var, file key,
+// type, default, required, description and deprecation) and of every
+// subcommand, with the options of its own config struct, as Markdown. Hidden options are left out, advanced ones marked.
+func (c *Config) WriteMarkdown(w io.Writer) error {
+	rows := c.docRows()
+	fmt.Fprintf(w, "# %s\n\n", c.Cmd.Name())
+	if desc := c.description(c.Cmd); desc != "" {
+		fmt.Fprintf(w, "%s\n\n", desc)
+	}
+	fmt.Fprintf(w, "```\n%s\n```\n\n", c.Cmd.UseLine())
+	fmt.Fprintf(w, "## Options\n\n")
+	writeMarkdownTable(w, rows)
+	if others := c.otherFlags(c.flags()); len(others) > 0 {
+		fmt.Fprintf(w, "\n## Other flags\n\n")
+		for _, fl := range others {
+			fmt.Fprintf(w, "- `--%s`: %s\n", fl.Name, fl.Usage)
+		}
+	}
+	if cmds := subcommands(c.Cmd); len(cmds) > 0 {
+		fmt.Fprintf(w, "\n## Commands\n")
+		for _, sub := range cmds {
+			fmt.Fprintf(w, "\n### %s\n\n", sub.CommandPath())
+			if desc := c.description(sub); desc != "" {
+				fmt.Fprintf(w, "%s\n\n", desc)
+			}
+			fmt.Fprintf(w, "```\n%s\n```\n", sub.UseLine())
+			others := localFlags(sub)
+			if sc := c.configOf(sub); sc != nil {
+				fmt.Fprintln(w)
+				writeMarkdownTable(w, sc.docRows())
+				others = sc.otherFlags(sub.LocalFlags())
+			}
+			if len(others) > 0 {
+				fmt.Fprintln(w)
+			}
+			for _, fl := range others {
+				fmt.Fprintf(w, "- `--%s`: %s\n", fl.Name, fl.Usage)
+			}
+		}
+	}
+	return nil
+}
+
+func writeMarkdownTable(w io.Writer, rows []docRow) {
+	fmt.Fprintf(w, "| Flag | Env | File key | Type | Default | Required | Description |\n")
+	fmt.Fprintf(w, "|------|-----|----------|------|---------|----------|-------------|\n")
+	for _, r := range rows {
+		desc := r.Description
+		if r.Advanced {
+			desc = strings.TrimSpace("*Advanced.* " + desc)
+		}
+		if r.Deprecated != "" {
+			desc = strings.TrimSpace("**Deprecated:** " + r.Deprecated + " " + desc)
+		}
+		if len(r.Aliases) > 0 {
+			desc = strings.TrimSpace(desc + " Deprecated names: `" + strings.Join(r.Aliases, "`, `") + "`.")
+		}
+		required := ""
+		if r.Required {
+			required = "yes"
+		}
+		fmt.Fprintf(w, "| %s | %s | %s | %s | %s | %s | %s |\n",
+			mdCode(r.Flag), mdCode(r.Env), mdCode(r.Key), r.Type, mdCode(r.Default), required, mdEscape(desc))
+	}
+}
+
+// WriteMan writes the same reference as WriteMarkdown as a roff man page in
+// section 1, dated date. A zero date leaves it out, so the page only changes
+// when the options do.
+func (c *Config) WriteMan(w io.Writer, date time.Time) error {
+	rows := c.docRows()
+	name := c.Cmd.Name()
+	var month string
+	if !date.IsZero() {
+		month = date.Format("January 2006")
+	}
+	fmt.Fprintf(w, ".TH %s 1 %q\n", strings.ToUpper(roff(name)), month)
+	fmt.Fprintf(w, ".SH NAME\n%s", roff(name))
+	if c.Cmd.Short != "" {
+		fmt.Fprintf(w, " \\- %s", roff(c.Cmd.Short))
+	}
+	fmt.Fprintf(w, "\n.SH SYNOPSIS\n.B %s\n", roff(c.Cmd.UseLine()))
+	if desc := c.description(c.Cmd); desc != "" {
+		fmt.Fprintf(w, ".SH DESCRIPTION\n%s\n", roff(desc))
+	}
+	fmt.Fprintf(w, ".SH OPTIONS\n")
+	writeManOptions(w, rows, c.otherFlags(c.flags()))
+	if cmds := subcommands(c.Cmd); len(cmds) > 0 {
+		fmt.Fprintf(w, ".SH COMMANDS\n")
+		for _, sub := range cmds {
+			fmt.Fprintf(w, ".TP\n.B %s\n%s\n", roff(sub.UseLine()), roff(c.description(sub)))
+			var rows []docRow
+			others := localFlags(sub)
+			if sc := c.configOf(sub); sc != nil {
+				rows, others = sc.docRows(), sc.otherFlags(sub.LocalFlags())
+			}
+			if len(rows)+len(others) > 0 {
+				fmt.Fprintf(w, ".RS\n")
+				writeManOptions(w, rows, others)
+				fmt.Fprintf(w, ".RE\n")
+			}
+		}
+	}
+	return nil
+}
+
+func writeManOptions(w io.Writer, rows []docRow, others []*pflag.Flag) {
+	for _, r := range rows {
+		option := r.Flag
+		if option == "" {
+			option = r.Env
+		}
+		fmt.Fprintf(w, ".TP\n.BR %s \" \" \\fI%s\\fR\n", roff(option), roff(r.Type))
+		if r.Deprecated != "" {
+			fmt.Fprintf(w, "Deprecated: %s\n.br\n", roff(r.Deprecated))
+		}
+		if r.Description != "" {
+			fmt.Fprintf(w, "%s\n.br\n", roff(r.Description))
+		}
+		var details []string
+		if r.Env != "" {
+			details = append(details, "Env: "+r.Env)
+		}
+		if r.Key != "" {
+			details = append(details, "file key: "+r.Key)
+		}
+		if r.Default != "" {
+			details = append(details, "default: "+r.Default)
+		}
+		if r.Required {
+			details = append(details, "required")
+		}
+		if r.Advanced {
+			details = append(details, "advanced")
+		}
+		if len(details) > 0 {
+			fmt.Fprintf(w, "%s.\n", roff(strings.Join(details, ", ")))
+		}
+		if len(r.Aliases) > 0 {
+			fmt.Fprintf(w, ".br\nDeprecated names: %s.\n", roff(strings.Join(r.Aliases, ", ")))
+		}
+	}
+	for _, fl := range others {
+		fmt.Fprintf(w, ".TP\n.B \\-\\-%s\n%s\n", roff(fl.Name), roff(fl.Usage))
+	}
+}
+
+// configOf is the Config of cmd, c or one of its subcommands', or nil.
+func (c *Config) configOf(cmd *cobra.Command) *Config {
+	for _, cc := range c.all() {
+		if cc.Cmd == cmd {
+			return cc
+		}
+	}
+	return nil
+}
+
+// localFlags are the visible flags of a command without a Config.
+func localFlags(cmd *cobra.Command) []*pflag.Flag {
+	var flags []*pflag.Flag
+	cmd.LocalFlags().VisitAll(func(fl *pflag.Flag) {
+		if !fl.Hidden {
+			flags = append(flags, fl)
+		}
+	})
+	return flags
+}
+
+func (c *Config) description(cmd *cobra.Command) string {
+	if cmd.Long != "" {
+		return cmd.Long
+	}
+	return cmd.Short
+}
+
+// subcommands lists every visible command below cmd, depth first.
+func subcommands(cmd *cobra.Command) []*cobra.Command {
+	var cmds []*cobra.Command
+	for _, sub := range cmd.Commands() {
+		if sub.Hidden || sub.Deprecated != "" {
+			continue
+		}
+		cmds = append(cmds, sub)
+		cmds = append(cmds, subcommands(sub)...)
+	}
+	return cmds
+}
+
+func mdEscape(s string) string {
+	return strings.Replace(s, "|", `\|`, -1)
+}
+
+func mdCode(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "`" + mdEscape(s) + "`"
+}
+
+// roff escapes s for use in a man page.
+func roff(s string) string {
+	s = strings.Replace(s, `\`, `\e`, -1)
+	s = strings.Replace(s, "-", `\-`, -1)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
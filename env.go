@@ -0,0 +1,133 @@
+package config
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// SetEnv makes the Config read env vars from env instead of the process
+// environment. A nil env restores the process environment.
+func (c *Config) SetEnv(env map[string]string) {
+	c.env = env
+}
+
+// SetOutput sets where printed output (--print-config, --help-env, init) and
+// cobra's usage, help and errors go. A nil writer keeps os.Stdout / os.Stderr.
+func (c *Config) SetOutput(stdout, stderr io.Writer) {
+	c.out = stdout
+	c.Cmd.SetOutput(stderr)
+}
+
+// SetFs sets the filesystem used for all file access: reading the config file
+// and --config search paths, strict mode's line numbers and the init command.
+// It defaults to the OS filesystem; afero.NewMemMapFs() keeps tests hermetic.
+func (c *Config) SetFs(fs afero.Fs) {
+	c.afs = fs
+}
+
+// Overlay stacks layers over a read-only base. A file is read from the last
+// layer that has it, whole files shadow each other (keys are not merged) and
+// writes go to the last layer. For defaults shipped in the binary:
+//
+//	c.SetFs(config.Overlay(embedded, afero.NewOsFs()))
+func Overlay(base afero.Fs, layers ...afero.Fs) afero.Fs {
+	fs := afero.NewReadOnlyFs(base)
+	for _, layer := range layers {
+		fs = afero.NewCopyOnWriteFs(fs, layer)
+	}
+	return fs
+}
+
+func (c *Config) stdout() io.Writer {
+	if c.out == nil {
+		return c.Cmd.OutOrStdout()
+	}
+	return c.out
+}
+
+func (c *Config) fs() afero.Fs {
+	if c.parent != nil {
+		return c.root().fs()
+	}
+	if c.afs == nil {
+		c.afs = afero.NewOsFs()
+	}
+	return c.afs
+}
+
+// getenv looks up an env var. Like viper, an empty value counts as unset.
+func (c *Config) getenv(name string) (string, bool) {
+	env := c.root().env
+	v, ok := env[name]
+	if env == nil {
+		v, ok = os.LookupEnv(name)
+	}
+	return v, ok && v != ""
+}
+
+// environ lists the names of every env var set.
+func (c *Config) environ() []string {
+	var names []string
+	env := c.root().env
+	if env == nil {
+		for _, kv := range os.Environ() {
+			names = append(names, strings.SplitN(kv, "=", 2)[0])
+		}
+	}
+	for name := range env {
+		names = append(names, name)
+	}
+	return names
+}
+
+// applyEnv sets every flag not given on the command line from its env var,
+// or from a deprecated alias's env var, so flags win over env and env over
+// the config file.
+func (c *Config) applyEnv() Errors {
+	c.fromEnv = map[string]bool{}
+	flags := c.flags()
+	var errs Errors
+	for _, f := range c.fields {
+		lup := flags.Lookup(f.flag)
+		if lup == nil || c.flagChanged(f) || !f.allows(SourceEnv) {
+			continue
+		}
+		for _, n := range append([]*field{f}, f.aliases...) {
+			v, ok := c.getenv(n.env)
+			if !ok {
+				continue
+			}
+			if err := flags.Set(lup.Name, v); err != nil && f.secret() {
+				errs = append(errs, c.fieldError(f, &redactedError{err, v}))
+			} else if err != nil {
+				errs = append(errs, c.fieldError(f, err))
+			}
+			c.fromEnv[f.flag] = true
+			break
+		}
+	}
+	for _, name := range builtinFlags {
+		lup := flags.Lookup(name)
+		if lup == nil || lup.Changed {
+			continue
+		}
+		if v, ok := c.getenv(c.envVar(strings.Replace(name, "-", "_", -1))); ok {
+			flags.Set(name, v)
+		}
+	}
+	return errs
+}
+
+// flagChanged reports whether f was given on the command line under its own
+// name or an alias.
+func (c *Config) flagChanged(f *field) bool {
+	for _, n := range append([]*field{f}, f.aliases...) {
+		if lup := c.flags().Lookup(n.flag); lup != nil && lup.Changed {
+			return true
+		}
+	}
+	return false
+}
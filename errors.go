@@ -0,0 +1,129 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrRequired is reported for a `required:"true"` field that has not been set.
+var ErrRequired = errors.New("required option has not been set")
+
+// ErrHelpRequested is returned by Execute after printing help for --help.
+var ErrHelpRequested = errors.New("help requested")
+
+const redacted = "[REDACTED]"
+
+// Source is the layer a config value was taken from.
+type Source int
+
+const (
+	SourceNone Source = iota
+	SourceDefault
+	SourceCode // set in the struct before loading
+	SourceFile
+	SourceEnv
+	SourceFlag
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourceDefault:
+		return "default"
+	case SourceCode:
+		return "code"
+	case SourceFile:
+		return "file"
+	case SourceEnv:
+		return "env"
+	case SourceFlag:
+		return "flag"
+	}
+	return "unset"
+}
+
+// FieldError is a problem with a single config field.
+type FieldError struct {
+	Path   string // Go field path, e.g. Log.Level
+	Flag   string // e.g. --log-level, or <SRC> for a positional argument
+	Env    string // e.g. CONF_LOG_LEVEL
+	Key    string // config file key, e.g. log.level
+	Value  string // offending value, redacted for `secret:"true"` fields
+	Source Source
+	Err    error
+}
+
+func (e *FieldError) Error() string {
+	if e.Env == "" {
+		return fmt.Sprintf("%s (%s): %v", e.Path, e.Flag, e.Err)
+	}
+	return fmt.Sprintf("%s (%s, $%s): %v", e.Path, e.Flag, e.Env, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// Errors is every problem found while loading a config. Use errors.As to
+// inspect it:
+//
+//	var errs config.Errors
+//	if errors.As(err, &errs) {
+//		for _, e := range errs {
+//			fmt.Println(e.Path, e.Flag, e.Env, e.Key, e.Value, e.Source, e.Err)
+//		}
+//	}
+type Errors []*FieldError
+
+func (e Errors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = "\t" + fe.Error()
+	}
+	return fmt.Sprintf("%d config errors:\n%s", len(e), strings.Join(msgs, "\n"))
+}
+
+func (e Errors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, fe := range e {
+		errs[i] = fe
+	}
+	return errs
+}
+
+// redactedError hides a secret's raw value in the message of err.
+type redactedError struct {
+	err   error
+	value string
+}
+
+func (e *redactedError) Error() string {
+	return strings.Replace(e.err.Error(), e.value, redacted, -1)
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// err avoids handing back a non-nil error interface holding an empty Errors.
+func (e Errors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// CollisionError is a flag or env var name that more than one field, or a
+// field and a built-in flag, would use. Config can't tell them apart, so it
+// refuses the struct rather than letting one shadow the other.
+type CollisionError struct {
+	Name  string   // e.g. --sub-string, -s or CONF_SUB_STRING
+	Paths []string // Go field paths, or "built-in --config" and the like
+}
+
+func (e *CollisionError) Error() string {
+	return fmt.Sprintf("name collision: %s is used by %s", e.Name, strings.Join(e.Paths, " and "))
+}
@@ -13,6 +13,10 @@ type LogConfig struct {
 	FD    int    `desc:"unix File Descriptor number"`
 }
 
+type VersionConfig struct {
+	Short bool `desc:"print only the version number"`
+}
+
 type Config struct {
 	Addr     string `desc:"address to listen on" def:"http://0.0.0.0:9999"`
 	Log      LogConfig
@@ -43,6 +47,12 @@ func ConfigExit(err error) {
 }
 
 func versionRun(cmd *cobra.Command, args []string) {
+	ctx := config.CommandContext(cmd)
+	cfg, _ := config.FromContext[Config](ctx)
+	versionCfg, _ := config.FromContext[VersionConfig](ctx)
+	if !versionCfg.Short {
+		fmt.Printf("configTest listening on %s, version ", cfg.Addr)
+	}
 	fmt.Printf("v1.2.0")
 }
 
@@ -52,17 +62,15 @@ func configRun(cmd *cobra.Command, args []string) {
 		ConfigExit(globalErr)
 	}()
 	// DO STUFF
-	fmt.Printf("%+v\n", cfg)
+	cfg, _ := config.FromContext[Config](config.CommandContext(cmd))
+	fmt.Printf("%+v\n", *cfg)
 }
 
-var cfg Config
-
 func main() {
-	cfg = Config{}
-	Cmd.AddCommand(VersionCmd)
-	c := config.NewWithCommand(Cmd, &cfg)
+	c := config.NewWithCommand(Cmd, &Config{})
+	c.AddCommand(VersionCmd, &VersionConfig{})
 	_, err := c.Execute() // cfgInterface === cfg
-	if err != nil {
+	if err != nil && err != config.ErrHelpRequested {
 		fmt.Println(err)
 		return
 	}
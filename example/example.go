@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/mikeynap/config"
 )
@@ -22,18 +23,18 @@ func main() {
 	cfg := Conf{}
 	c := config.New("configTest", "A Thingy To Run Commands", &cfg)
 
-	conf, err := c.Execute()
+	_, err := c.Execute()
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 	c.SetArgs([]string{"--required", "true", "--log-level", "error"})
-	conf, err = c.Execute()
+	_, err = c.Execute()
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
-	fmt.Printf("%+v\n", conf)
+	c.PrintConfig(os.Stdout, config.FormatYAML, true)
 
 }
@@ -0,0 +1,287 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// field is one leaf of the config struct, with every name it is known by.
+type field struct {
+	path    []string // Go field path, e.g. [Log Level]
+	flag    string   // flag name without dashes, e.g. log-level
+	env     string   // full env var name, e.g. CONF_LOG_LEVEL
+	key     string   // config file key, e.g. log.level
+	tag     reflect.StructTag
+	value   reflect.Value
+	aliases []*field // old names from `alias:"..."`, sharing path and value
+	section string   // help group, "" for top-level options
+	name    string   // the last path element in flag form, e.g. max-size
+}
+
+// Path returns the dotted Go field path (Log.Level).
+func (f *field) Path() string {
+	return strings.Join(f.path, ".")
+}
+
+func (f *field) desc() string {
+	if desc := f.tag.Get("desc"); desc != "" {
+		return desc
+	}
+	return f.tag.Get("description")
+}
+
+func (f *field) defaultTag() string {
+	if def := f.tag.Get("def"); def != "" {
+		return def
+	}
+	return f.tag.Get("default")
+}
+
+// enum lists the values allowed by an `enum:"a,b,c"` tag.
+func (f *field) enum() []string {
+	if f.tag.Get("enum") == "" {
+		return nil
+	}
+	return strings.Split(f.tag.Get("enum"), ",")
+}
+
+func (f *field) required() bool {
+	req, ok := f.tag.Lookup("required")
+	return ok && req != "false"
+}
+
+func (f *field) secret() bool {
+	return f.tag.Get("secret") == "true"
+}
+
+// hidden fields keep their flag, env var and file key but are left out of
+// help and docs.
+func (f *field) hidden() bool {
+	return f.tag.Get("hidden") == "true"
+}
+
+// advanced fields are only shown by --help-all.
+func (f *field) advanced() bool {
+	return f.tag.Get("advanced") == "true"
+}
+
+// newField describes subFieldName of parent, found below crumbs in the cfg struct.
+func (c *Config) newField(parent reflect.Value, subFieldName string, crumbs []string) *field {
+	sf, _ := parent.Type().FieldByName(subFieldName)
+	n := c.root().naming
+	f := &field{
+		path:  append(append([]string{}, crumbs...), subFieldName),
+		flag:  c.flagFor(crumbs, subFieldName),
+		env:   c.envVar(c.envFor(crumbs, subFieldName)),
+		key:   c.keyFor(crumbs, subFieldName, &sf),
+		tag:   sf.Tag,
+		value: parent.FieldByName(subFieldName),
+		name:  c.flagFor(nil, subFieldName),
+	}
+	if name := flagName(sf); name != "" {
+		f.flag = joinName(c.flagFor(crumbs, ""), name, n.flagSeparator())
+	}
+	if name, opts := envName(sf); name != "" && opts == "noprefix" {
+		f.env = name
+	} else if name != "" {
+		f.env = c.envVar(joinName(c.envFor(crumbs, ""), name, n.envSeparator()))
+	}
+	f.section = section(reflect.TypeOf(c.cfg), crumbs, f)
+	for _, alias := range aliases(sf) {
+		f.aliases = append(f.aliases, &field{
+			path:  f.path,
+			flag:  c.flagFor(crumbs, alias),
+			env:   c.envVar(c.envFor(crumbs, alias)),
+			key:   c.keyFor(crumbs, alias, nil),
+			tag:   sf.Tag,
+			value: f.value,
+		})
+	}
+	return f
+}
+
+// envVar prefixes envStr with the command name, e.g. CONF_LOG_LEVEL, and for
+// subcommands also with their name, e.g. CONF_SERVE_PORT.
+func (c *Config) envVar(envStr string) string {
+	sep := c.root().naming.envSeparator()
+	if c.parent != nil {
+		return c.parent.envVar(strings.Replace(c.Cmd.Name(), "-", "_", -1) + sep + envStr)
+	}
+	if c.envPrefix() == "" {
+		return strings.ToUpper(envStr)
+	}
+	return strings.ToUpper(c.envPrefix() + sep + envStr)
+}
+
+// source reports which layer the current value of f came from.
+func (c *Config) source(f *field) Source {
+	if c.fromEnv[f.flag] {
+		return SourceEnv
+	}
+	if c.argSet[f.Path()] {
+		return SourceFlag
+	}
+	if lup := c.flags().Lookup(f.flag); lup != nil && lup.Changed {
+		return SourceFlag
+	}
+	for _, n := range append([]*field{f}, f.aliases...) {
+		if c.file != nil && c.file.Get(n.key) != nil {
+			return SourceFile
+		}
+	}
+	if s, ok := c.origin[f.Path()]; ok {
+		return s
+	}
+	if f.defaultTag() != "" {
+		return SourceDefault
+	}
+	return SourceNone
+}
+
+// fieldAt is the field with the dotted Go path, or nil.
+func (c *Config) fieldAt(path string) *field {
+	for _, f := range c.fields {
+		if f.Path() == path {
+			return f
+		}
+	}
+	return nil
+}
+
+// applyKeys unmarshals each field from its own file key, so that `key` tags,
+// which mapstructure doesn't know, are honoured.
+func (c *Config) applyKeys() error {
+	if c.file == nil {
+		return nil
+	}
+	for _, f := range c.fields {
+		if c.file.Get(f.key) == nil {
+			continue
+		}
+		if err := c.file.UnmarshalKey(f.key, f.value.Addr().Interface()); err != nil {
+			return c.fieldError(f, err)
+		}
+	}
+	return nil
+}
+
+// fieldError builds a FieldError for f, redacting the value of secrets.
+func (c *Config) fieldError(f *field, err error) *FieldError {
+	v := fmt.Sprintf("%v", f.value)
+	if f.secret() && !isZeroStr(v) {
+		v = redacted
+	}
+	return &FieldError{
+		Path:   f.Path(),
+		Flag:   "--" + f.flag,
+		Env:    f.env,
+		Key:    f.key,
+		Value:  v,
+		Source: c.source(f),
+		Err:    err,
+	}
+}
+
+// fileKey is the (viper, lower case) key of name in a config file. Names follow
+// mapstructure, so a `mapstructure:"name"` tag is honoured at every level.
+func fileKey(root reflect.Type, crumbs []string, name string) string {
+	for root.Kind() == reflect.Ptr {
+		root = root.Elem()
+	}
+	var keys []string
+	for _, crumb := range crumbs {
+		parent, ok := root.FieldByName(crumb)
+		if !ok {
+			break
+		}
+		keys = append(keys, keyName(parent))
+		root = parent.Type
+	}
+	return strings.ToLower(strings.Join(append(keys, name), "."))
+}
+
+// keyName is the file key of sf within its table: its `key` tag, else its
+// mapstructure name.
+func keyName(sf reflect.StructField) string {
+	if name := sf.Tag.Get("key"); name != "" {
+		return name
+	}
+	return mapstructureName(sf)
+}
+
+// flagName is the name from a `flag:"name"` tag, relative to the parent struct.
+func flagName(sf reflect.StructField) string {
+	if name := sf.Tag.Get("flag"); name != "false" && name != "true" {
+		return name
+	}
+	return ""
+}
+
+// envName splits an `env:"NAME[,noprefix]"` tag. NAME is relative to the
+// parent struct and the command prefix, unless noprefix is given.
+func envName(sf reflect.StructField) (name, opts string) {
+	parts := strings.SplitN(sf.Tag.Get("env"), ",", 2)
+	if len(parts) > 1 {
+		opts = parts[1]
+	}
+	return strings.ToUpper(parts[0]), opts
+}
+
+func joinName(parent, name, sep string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + sep + name
+}
+
+func mapstructureName(sf reflect.StructField) string {
+	if name := strings.Split(sf.Tag.Get("mapstructure"), ",")[0]; name != "" {
+		return name
+	}
+	return sf.Name
+}
+
+// recordOrigin marks every non-zero field not yet recorded as coming from s.
+func (c *Config) recordOrigin(s Source) {
+	eachSubField(c.cfg, func(parent reflect.Value, subFieldName string, crumbs []string) error {
+		path := strings.Join(append(append([]string{}, crumbs...), subFieldName), ".")
+		if v := parent.FieldByName(subFieldName).Interface(); !isZero(v) {
+			if _, ok := c.origin[path]; !ok {
+				c.origin[path] = s
+			}
+			c.preset[path] = v
+		}
+		return nil
+	})
+}
+
+// defaultValue is what f holds before the file, env and flags are applied:
+// its value in the struct or from SetDefaults, else its default tag.
+func (c *Config) defaultValue(f *field) interface{} {
+	if v, ok := c.preset[f.Path()]; ok {
+		return v
+	}
+	def := f.defaultTag()
+	v := reflect.New(f.value.Type()).Elem()
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(def)
+	case reflect.Bool:
+		b, _ := strconv.ParseBool(def)
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		i, _ := strconv.ParseInt(def, 10, 64)
+		v.SetInt(i)
+	case reflect.Float32, reflect.Float64:
+		fl, _ := strconv.ParseFloat(def, 64)
+		v.SetFloat(fl)
+	case reflect.Slice:
+		if def != "" {
+			return strings.Split(def, ",")
+		}
+		return []string{}
+	}
+	return v.Interface()
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// BindFlagSet registers a flag for every field of cfg, and --config, on fs,
+// for programs that don't use cobra. Call ParseFlagSet to populate cfg from
+// fs, env vars, the config file and defaults, with the same required,
+// constraint and Validator checks as Execute. Env vars are prefixed with the
+// name of the executable, os.Args[0], unless WithEnvPrefix is given.
+func BindFlagSet(fs *pflag.FlagSet, cfg interface{}, opts ...Option) (*Config, error) {
+	return bindFlagSet(filepath.Base(os.Args[0]), fs, cfg, opts)
+}
+
+// BindGoFlagSet is BindFlagSet for the standard library's flag package. Env
+// vars are prefixed with the name of fs.
+func BindGoFlagSet(fs *flag.FlagSet, cfg interface{}, opts ...Option) (*Config, error) {
+	c, err := bindFlagSet(filepath.Base(fs.Name()), pflag.NewFlagSet(fs.Name(), pflag.ContinueOnError), cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.goFlagSet = fs
+	// pflag values satisfy flag.Value, and bools keep IsBoolFlag.
+	c.flagSet.VisitAll(func(f *pflag.Flag) {
+		if !c.flagless(f.Name) {
+			fs.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	return c, nil
+}
+
+func bindFlagSet(name string, fs *pflag.FlagSet, cfg interface{}, opts []Option) (*Config, error) {
+	if err := checkShape(cfg); err != nil {
+		return nil, err
+	}
+	c := &Config{
+		Viper:   viper.New(),
+		Cmd:     &cobra.Command{Use: name},
+		cfg:     cfg,
+		flagSet: fs,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if err := c.checkCollisions(); err != nil {
+		return nil, err
+	}
+	fs.String("config", "", "The configuration file")
+	c.Viper.BindPFlag("config", fs.Lookup("config"))
+	c.setup()
+	return c, nil
+}
+
+// ParseFlagSet parses args with the flag set given to BindFlagSet or
+// BindGoFlagSet and loads the config. Pass nil args if the flag set has
+// already been parsed; its flags and positional args are used as they are. -h and --help return ErrHelpRequested. It can only be
+// called once.
+func (c *Config) ParseFlagSet(args []string) error {
+	if c.flagSet == nil {
+		return errors.New("ParseFlagSet needs a Config from BindFlagSet or BindGoFlagSet")
+	}
+	if c.parsed {
+		return errors.New("ParseFlagSet can only be called once")
+	}
+	c.SetArgs(args)
+	if _, err := c.parse(); err != nil {
+		return c.onError(err)
+	}
+	return c.onError(c.checkAll())
+}
+
+// parseFlagSet parses the args with a flag set not owned by cobra. Nil args
+// keep what an earlier Parse left, including the positional args.
+func (c *Config) parseFlagSet() error {
+	var err error
+	if c.goFlagSet != nil {
+		if c.Args != nil || !c.goFlagSet.Parsed() {
+			err = c.goFlagSet.Parse(c.Args)
+		}
+		c.goFlagSet.Visit(func(f *flag.Flag) {
+			// The program's own flags have no pflag twin.
+			if lup := c.flagSet.Lookup(f.Name); lup != nil {
+				lup.Changed = true
+			}
+		})
+	} else if c.Args != nil || !c.flagSet.Parsed() {
+		err = c.flagSet.Parse(c.Args)
+	}
+	if err == flag.ErrHelp || err == pflag.ErrHelp {
+		return ErrHelpRequested
+	}
+	return err
+}
+
+// flags is where the fields' flags live: the command's persistent flags, a
+// subcommand's local flags, or the flag set given to BindFlagSet.
+func (c *Config) flags() *pflag.FlagSet {
+	switch {
+	case c.flagSet != nil:
+		return c.flagSet
+	case c.parent != nil:
+		return c.Cmd.Flags()
+	}
+	return c.Cmd.PersistentFlags()
+}
@@ -0,0 +1,182 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// AddConfigPath adds directories searched for <command name>.<ext> (any
+// extension viper supports) when --config isn't given. The first found wins.
+func (c *Config) AddConfigPath(dirs ...string) {
+	c.configPaths = append(c.configPaths, dirs...)
+}
+
+func (c *Config) findConfig() string {
+	for _, dir := range c.configPaths {
+		for _, ext := range viper.SupportedExts {
+			p := filepath.Join(dir, c.Cmd.Name()+"."+ext)
+			if _, err := c.fs().Stat(p); err == nil {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// section is the help group of a field: its own `group` tag, else the closest
+// `group` tag on a parent struct, else its struct path.
+func section(root reflect.Type, crumbs []string, f *field) string {
+	if name, _ := f.group(); name != "" {
+		return name
+	}
+	for root.Kind() == reflect.Ptr {
+		root = root.Elem()
+	}
+	name := strings.Join(crumbs, ".")
+	for i, crumb := range crumbs {
+		parent, ok := root.FieldByName(crumb)
+		if !ok {
+			break
+		}
+		if group := strings.Split(parent.Tag.Get("group"), ",")[0]; group != "" {
+			name = strings.Join(append([]string{group}, crumbs[i+1:]...), ".")
+		}
+		root = parent.Type
+	}
+	return name
+}
+
+// usage replaces cobra's flat flag list: options are grouped by sub-struct,
+// each shows its env var, and the config file locations come last.
+func (c *Config) usage(cmd *cobra.Command) error {
+	w := cmd.OutOrStderr()
+	fmt.Fprintf(w, "Usage:\n")
+	if cmd.Runnable() {
+		fmt.Fprintf(w, "  %s\n", cmd.UseLine())
+	}
+	if cmd.HasAvailableSubCommands() {
+		fmt.Fprintf(w, "  %s [command]\n", cmd.CommandPath())
+	}
+	if cmd.HasExample() {
+		fmt.Fprintf(w, "\nExamples:\n%s\n", cmd.Example)
+	}
+	if cmd.HasAvailableSubCommands() {
+		fmt.Fprintf(w, "\nAvailable Commands:\n")
+		for _, sub := range cmd.Commands() {
+			if sub.IsAvailableCommand() {
+				fmt.Fprintf(w, "  %-*s %s\n", cmd.NamePadding(), sub.Name(), sub.Short)
+			}
+		}
+	}
+
+	others := c.otherFlags(cmd.LocalFlags())
+	if cmd != c.Cmd {
+		if cmd.HasAvailableLocalFlags() {
+			fmt.Fprintf(w, "\nFlags:\n%s", cmd.LocalFlags().FlagUsages())
+		}
+		others = c.otherFlags(cmd.InheritedFlags())
+	} else if c.parent != nil {
+		others = c.otherFlags(cmd.Flags())
+	}
+	advanced := c.writeOptions(w, "")
+	for p := c.parent; p != nil; p = p.parent {
+		advanced = p.writeOptions(w, "Global ") || advanced
+	}
+	if len(others) > 0 {
+		fs := pflag.NewFlagSet(cmd.Name(), pflag.ContinueOnError)
+		for _, fl := range others {
+			fs.AddFlag(fl)
+		}
+		fmt.Fprintf(w, "\nOther flags:\n%s", fs.FlagUsages())
+	}
+
+	root := c.root()
+	fmt.Fprintf(w, "\nConfig file:\n  --config FILE or $%s\n", root.envVar("config"))
+	if len(root.configPaths) > 0 {
+		fmt.Fprintf(w, "  otherwise the first %s.{%s} in: %s\n",
+			root.Cmd.Name(), strings.Join(viper.SupportedExts, ","), strings.Join(root.configPaths, ", "))
+	}
+	if advanced {
+		fmt.Fprintf(w, "\nUse \"%s --help-all\" to include advanced options.\n", cmd.CommandPath())
+	}
+	if cmd.HasAvailableSubCommands() {
+		fmt.Fprintf(w, "\nUse \"%s [command] --help\" for more information about a command.\n", cmd.CommandPath())
+	}
+	return nil
+}
+
+// helpAll reports whether --help-all was given.
+func (c *Config) helpAll() bool {
+	return c.root().Viper.GetBool("help-all")
+}
+
+// writeOptions writes one flag table per section, with env vars. It reports
+// whether it left out advanced options.
+func (c *Config) writeOptions(w io.Writer, scope string) (advanced bool) {
+	var order []string
+	sections := map[string]*pflag.FlagSet{}
+	for _, f := range c.fields {
+		lup := c.flags().Lookup(f.flag)
+		if lup == nil || lup.Hidden {
+			continue
+		}
+		if f.advanced() && !c.helpAll() {
+			advanced = true
+			continue
+		}
+		fs, ok := sections[f.section]
+		if !ok {
+			fs = pflag.NewFlagSet(f.section, pflag.ContinueOnError)
+			sections[f.section] = fs
+			order = append(order, f.section)
+		}
+		fl := *lup
+		fl.Usage = strings.TrimSpace(fl.Usage + " [$" + f.env + "]")
+		if f.secret() && fl.DefValue != "" {
+			fl.DefValue = redacted
+		}
+		fs.AddFlag(&fl)
+	}
+	for _, name := range order {
+		if !sections[name].HasAvailableFlags() {
+			continue
+		}
+		title := scope + "Options"
+		if name != "" {
+			title = scope + name + " options"
+		}
+		fmt.Fprintf(w, "\n%s:\n%s", title, sections[name].FlagUsages())
+	}
+	return advanced
+}
+
+// WriteEnvHelp lists every env var the config reads, with its description,
+// leaving out hidden options and, unless --help-all is given, advanced ones.
+// It is also available on the command line as --help-env.
+func (c *Config) WriteEnvHelp(w io.Writer) error {
+	if c.fields == nil {
+		c.setup()
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, f := range c.fields {
+		lup := c.flags().Lookup(f.flag)
+		if f.hidden() || lup != nil && lup.Hidden && f.allows(SourceFlag) || !f.allows(SourceEnv) || f.advanced() && !c.helpAll() {
+			continue
+		}
+		desc := f.desc()
+		if def := f.defaultTag(); def != "" && !f.secret() {
+			desc = strings.TrimSpace(fmt.Sprintf("%s (default %q)", desc, def))
+		}
+		fmt.Fprintf(tw, "  %s\t%s\n", f.env, desc)
+	}
+	fmt.Fprintf(tw, "  %s\t%s\n", c.envVar("config"), "The configuration file")
+	return tw.Flush()
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// Hooks let an application run code at points of Execute and ParseFlagSet.
+// Every hook is optional and an error from one stops loading. The command's
+// own PersistentPreRun, PreRun and Run hooks are kept and run as usual; the
+// config checks run just before its PreRun.
+type Hooks struct {
+	// BeforeLoad runs once the flags are registered, before the args, env
+	// vars and config file are read.
+	BeforeLoad func(*Config) error
+	// AfterMerge runs once every layer is in the struct, before any check.
+	AfterMerge func(*Config) error
+	// AfterValidate runs once every check has passed, before the command's
+	// PreRun and Run. It is the place to e.g. set up logging from the config.
+	AfterValidate func(*Config) error
+	// OnError sees every error Execute or ParseFlagSet returns, except
+	// ErrHelpRequested, and returns the one to report (nil to ignore it).
+	OnError func(*Config, error) error
+}
+
+// SetHooks sets the lifecycle hooks. Subcommand configs use their root's.
+func (c *Config) SetHooks(h Hooks) {
+	c.hooks = h
+}
+
+// WithHooks is SetHooks as an Option.
+func WithHooks(h Hooks) Option {
+	return func(c *Config) { c.SetHooks(h) }
+}
+
+// hookPreRun makes c.Cmd check the config before running, then run the
+// PreRun or PreRunE it already had.
+func (c *Config) hookPreRun() {
+	pre, preE := c.Cmd.PreRun, c.Cmd.PreRunE
+	c.Cmd.PreRun = nil
+	c.Cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		if err := c.checkAll(); err != nil {
+			return err
+		}
+		if preE != nil {
+			return preE(cmd, args)
+		}
+		if pre != nil {
+			pre(cmd, args)
+		}
+		return nil
+	}
+}
+
+// checkAll checks the root config down to c, then runs AfterValidate.
+func (c *Config) checkAll() error {
+	var chain []*Config
+	for p := c; p != nil; p = p.parent {
+		chain = append([]*Config{p}, chain...)
+	}
+	for _, p := range chain {
+		if err := p.check(); err != nil {
+			return err
+		}
+	}
+	return c.runHook(c.root().hooks.AfterValidate)
+}
+
+func (c *Config) runHook(hook func(*Config) error) error {
+	if hook == nil {
+		return nil
+	}
+	return hook(c)
+}
+
+// onError passes err through the OnError hook.
+func (c *Config) onError(err error) error {
+	if err == nil || err == ErrHelpRequested || c.hooks.OnError == nil {
+		return err
+	}
+	return c.hooks.OnError(c, err)
+}
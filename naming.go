@@ -0,0 +1,242 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// naming is how flag, env and file key names are derived from Go field paths.
+// The zero value is the default: --log-level, CONF_LOG_LEVEL and log.level.
+type naming struct {
+	envPrefix *string                    // nil for the command name
+	flagSep   string                     // between struct levels, "" for "-"
+	envSep    string                     // between all parts, "" for "_"
+	keyNamer  func(path []string) string // nil for mapstructure names
+	acronyms  []string                   // words never split, see WithAcronyms
+}
+
+// WithEnvPrefix sets the env var prefix, which defaults to the command name.
+// An empty prefix means none.
+func WithEnvPrefix(prefix string) Option {
+	return func(c *Config) { c.naming.envPrefix = &prefix }
+}
+
+// WithNoEnvPrefix reads env vars without a prefix, e.g. LOG_LEVEL. Strict
+// mode then can't tell which env vars are meant for the command and ignores
+// unknown ones.
+func WithNoEnvPrefix() Option {
+	return WithEnvPrefix("")
+}
+
+// WithFlagSeparator sets the separator between struct levels in flag names,
+// e.g. '.' for --log.level. Words within a level are still joined with '-'.
+func WithFlagSeparator(sep rune) Option {
+	return func(c *Config) { c.naming.flagSep = string(sep) }
+}
+
+// WithEnvSeparator sets the separator between the prefix and struct levels in
+// env var names, e.g. "__" for APP__LOG__LEVEL. Words within a level are still
+// joined with '_'.
+func WithEnvSeparator(sep string) Option {
+	return func(c *Config) { c.naming.envSep = sep }
+}
+
+// WithKeyNamer derives config file keys from Go field paths ([Log Level]),
+// e.g. to use snake_case. Dots in the result are tables. A `key` tag on the
+// field itself still wins.
+func WithKeyNamer(namer func(path []string) string) Option {
+	return func(c *Config) { c.naming.keyNamer = namer }
+}
+
+// WithAcronyms adds words, like "OAuth", "gRPC" or "IPv4", that are kept
+// whole in flag and env var names: with OAuth, OAuthToken and OAuth2Token are
+// --oauth-token and --oauth2-token rather than --o-auth-token and
+// --o-auth2-token. They are matched case sensitively where a word starts.
+// All-caps acronyms (URL, TLS) are already kept whole when followed by a
+// capitalised word, so they rarely need adding. None are registered by
+// default, as each one renames existing flags and env vars.
+func WithAcronyms(words ...string) Option {
+	return func(c *Config) { c.naming.acronyms = append(c.naming.acronyms, words...) }
+}
+
+func (n naming) flagSeparator() string {
+	if n.flagSep == "" {
+		return "-"
+	}
+	return n.flagSep
+}
+
+func (n naming) envSeparator() string {
+	if n.envSep == "" {
+		return "_"
+	}
+	return n.envSep
+}
+
+// flagFor is the flag name of name below crumbs, or of crumbs if name is "".
+func (c *Config) flagFor(crumbs []string, name string) string {
+	n := c.root().naming
+	if n.flagSep == "" {
+		return flagString(strings.Join(crumbs, ""), name, n.acronyms...)
+	}
+	return joinParts(append(append([]string{}, crumbs...), name), n.flagSep, func(s string) string {
+		return strings.ToLower(hyphen(s, n.acronyms...))
+	})
+}
+
+// envFor is the unprefixed env var name of name below crumbs, or of crumbs if
+// name is "".
+func (c *Config) envFor(crumbs []string, name string) string {
+	n := c.root().naming
+	if n.envSep == "" {
+		return envString(strings.Join(crumbs, ""), name, n.acronyms...)
+	}
+	return joinParts(append(append([]string{}, crumbs...), name), n.envSep, func(s string) string {
+		return strings.ToUpper(underscore(s, n.acronyms...))
+	})
+}
+
+// keyFor is the file key of name below crumbs. sf is the field itself, nil
+// for an alias.
+func (c *Config) keyFor(crumbs []string, name string, sf *reflect.StructField) string {
+	if namer := c.root().naming.keyNamer; namer != nil && (sf == nil || sf.Tag.Get("key") == "") {
+		return c.keyPrefix() + strings.ToLower(namer(append(append([]string{}, crumbs...), name)))
+	}
+	if sf != nil {
+		name = keyName(*sf)
+	}
+	return c.keyPrefix() + fileKey(reflect.TypeOf(c.cfg), crumbs, name)
+}
+
+// envPrefix is the prefix of every env var, "" for none.
+func (c *Config) envPrefix() string {
+	if p := c.root().naming.envPrefix; p != nil {
+		return *p
+	}
+	return c.root().Cmd.Name()
+}
+
+func joinParts(parts []string, sep string, conv func(string) string) string {
+	var out []string
+	for _, p := range parts {
+		if p != "" {
+			out = append(out, conv(p))
+		}
+	}
+	return strings.Join(out, sep)
+}
+
+// nameUse records which fields use each flag or env var name, in the order
+// the names were first seen.
+type nameUse struct {
+	names []string
+	paths map[string][]string
+}
+
+func (u *nameUse) add(name, path string) {
+	if u.paths == nil {
+		u.paths = map[string][]string{}
+	}
+	if _, ok := u.paths[name]; !ok {
+		u.names = append(u.names, name)
+	}
+	if !contains(u.paths[name], path) {
+		u.paths[name] = append(u.paths[name], path)
+	}
+}
+
+// collisions are the names used more than once.
+func (u *nameUse) collisions() []*CollisionError {
+	var errs []*CollisionError
+	for _, name := range u.names {
+		if paths := u.paths[name]; len(paths) > 1 {
+			errs = append(errs, &CollisionError{Name: name, Paths: paths})
+		}
+	}
+	return errs
+}
+
+// checkCollisions reports every flag, short flag and env var name derived
+// from more than one field of the root or a subcommand, or from a field and a
+// built-in. A subcommand's flags may collide with its parents' flags, its env
+// vars with any env var.
+func (c *Config) checkCollisions() error {
+	root := c.root()
+	builtins := append([]string{"help"}, builtinFlags...)
+	if root.flagSet != nil {
+		builtins = []string{"config"}
+	}
+	env := &nameUse{}
+	for _, name := range builtins {
+		if name != "help" {
+			env.add(root.envVar(strings.Replace(name, "-", "_", -1)), "built-in --"+name)
+		}
+	}
+	var errs []error
+	seen := map[string]bool{}
+	report := func(u *nameUse) {
+		for _, e := range u.collisions() {
+			if !seen[e.Error()] {
+				seen[e.Error()] = true
+				errs = append(errs, e)
+			}
+		}
+	}
+	for _, x := range root.all() {
+		flags := &nameUse{}
+		for _, name := range builtins {
+			flags.add("--"+name, "built-in --"+name)
+		}
+		if root.flagSet == nil {
+			flags.add("-h", "built-in --help")
+		}
+		var chain []*Config
+		for p := x; p != nil; p = p.parent {
+			chain = append([]*Config{p}, chain...)
+		}
+		for _, p := range chain {
+			for _, f := range p.namedFields() {
+				path := p.describe(f)
+				flags.add("--"+f.flag, path)
+				if short := f.tag.Get("short"); short != "" {
+					flags.add("-"+short, path)
+				}
+				for _, a := range f.aliases {
+					flags.add("--"+a.flag, path)
+				}
+				if p == x {
+					env.add(f.env, path)
+					for _, a := range f.aliases {
+						env.add(a.env, path)
+					}
+				}
+			}
+		}
+		report(flags)
+	}
+	report(env)
+	return errors.Join(errs...)
+}
+
+// namedFields derives the names of every field that gets a flag, without
+// registering anything.
+func (c *Config) namedFields() []*field {
+	var fields []*field
+	eachSubField(c.cfg, func(parent reflect.Value, subFieldName string, crumbs []string) error {
+		f := c.newField(parent, subFieldName, crumbs)
+		if _, ok := f.tag.Lookup("arg"); !ok {
+			fields = append(fields, f)
+		}
+		return nil
+	})
+	return fields
+}
+
+// describe is a field's Go path, with the subcommand it belongs to.
+func (c *Config) describe(f *field) string {
+	if c.parent == nil {
+		return f.Path()
+	}
+	return f.Path() + " (" + c.Cmd.Name() + ")"
+}
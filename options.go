@@ -0,0 +1,35 @@
+package config
+
+import (
+	"io"
+
+	"github.com/spf13/afero"
+)
+
+// Option configures a Config, see New, NewTyped, Load and BindFlagSet.
+type Option func(*Config)
+
+// WithArgs is SetArgs as an Option.
+func WithArgs(args ...string) Option {
+	return func(c *Config) { c.SetArgs(args) }
+}
+
+// WithEnv is SetEnv as an Option.
+func WithEnv(env map[string]string) Option {
+	return func(c *Config) { c.SetEnv(env) }
+}
+
+// WithOutput is SetOutput as an Option.
+func WithOutput(stdout, stderr io.Writer) Option {
+	return func(c *Config) { c.SetOutput(stdout, stderr) }
+}
+
+// WithFs is SetFs as an Option.
+func WithFs(fs afero.Fs) Option {
+	return func(c *Config) { c.SetFs(fs) }
+}
+
+// WithConfigPath is AddConfigPath as an Option.
+func WithConfigPath(dirs ...string) Option {
+	return func(c *Config) { c.AddConfigPath(dirs...) }
+}
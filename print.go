@@ -0,0 +1,206 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Format is an output format for PrintConfig.
+type Format string
+
+const (
+	FormatYAML Format = "yaml"
+	FormatJSON Format = "json"
+	FormatTOML Format = "toml"
+	FormatEnv  Format = "env" // export CONF_X=... shell lines
+)
+
+// PrintConfig writes the merged config to w. Secrets are redacted, and
+// FormatEnv leaves out fields that can't be set from the environment. With
+// sources, every value is annotated with the layer it came from. It is also
+// available on the command line as --print-config FORMAT [--print-config-sources].
+func (c *Config) PrintConfig(w io.Writer, format Format, sources bool) error {
+	r := &renderer{w: w, value: c.printValue}
+	if sources {
+		r.note = func(f *field) string { return c.source(f).String() }
+	}
+	var keep func(*field) bool
+	if format == FormatEnv {
+		keep = func(f *field) bool { return f.allows(SourceEnv) }
+	}
+	return r.render(c.tree(keep), format)
+}
+
+func (c *Config) printValue(f *field) interface{} {
+	if f.secret() && !isZero(f.value.Interface()) {
+		return redacted
+	}
+	return f.value.Interface()
+}
+
+// node is a config file table; leaves have a field.
+type node struct {
+	name     string
+	field    *field
+	children []*node
+}
+
+func (n *node) child(name string) *node {
+	for _, ch := range n.children {
+		if ch.name == name {
+			return ch
+		}
+	}
+	ch := &node{name: name}
+	n.children = append(n.children, ch)
+	return ch
+}
+
+// tree arranges the fields keep accepts (all, if nil) by file key, in struct
+// order.
+func (c *Config) tree(keep func(*field) bool) *node {
+	root := &node{}
+	for _, f := range c.fields {
+		if keep != nil && !keep(f) {
+			continue
+		}
+		n := root
+		for _, k := range strings.Split(f.key, ".") {
+			n = n.child(k)
+		}
+		n.field = f
+	}
+	return root
+}
+
+// renderer writes a tree of fields in one of the Formats.
+type renderer struct {
+	w     io.Writer
+	value func(*field) interface{}
+	note  func(*field) string   // trailing comment, if set
+	above func(*field) []string // comment lines before the key, if set
+}
+
+func (r *renderer) render(root *node, format Format) error {
+	switch format {
+	case FormatYAML:
+		r.yaml(root, "")
+	case FormatTOML:
+		r.toml(root, "")
+	case FormatEnv:
+		r.env(root)
+	case FormatJSON:
+		b, err := json.MarshalIndent(r.json(root), "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(r.w, "%s\n", b)
+	default:
+		return fmt.Errorf("unknown format %q, expected yaml, json, toml or env", format)
+	}
+	return nil
+}
+
+func (r *renderer) comment(f *field) string {
+	if r.note == nil {
+		return ""
+	}
+	return " # " + r.note(f)
+}
+
+func (r *renderer) commentAbove(f *field, indent string) {
+	if r.above == nil {
+		return
+	}
+	for _, line := range r.above(f) {
+		fmt.Fprintf(r.w, "%s# %s\n", indent, line)
+	}
+}
+
+func (r *renderer) yaml(n *node, indent string) {
+	for _, ch := range n.children {
+		if ch.field == nil {
+			fmt.Fprintf(r.w, "%s%s:\n", indent, ch.name)
+			r.yaml(ch, indent+"  ")
+			continue
+		}
+		r.commentAbove(ch.field, indent)
+		fmt.Fprintf(r.w, "%s%s: %s%s\n", indent, ch.name, literal(r.value(ch.field)), r.comment(ch.field))
+	}
+}
+
+func (r *renderer) toml(n *node, table string) {
+	for _, ch := range n.children {
+		if ch.field != nil {
+			r.commentAbove(ch.field, "")
+			fmt.Fprintf(r.w, "%s = %s%s\n", ch.name, literal(r.value(ch.field)), r.comment(ch.field))
+		}
+	}
+	for _, ch := range n.children {
+		if ch.field == nil {
+			name := strings.TrimPrefix(table+"."+ch.name, ".")
+			fmt.Fprintf(r.w, "\n[%s]\n", name)
+			r.toml(ch, name)
+		}
+	}
+}
+
+func (r *renderer) env(n *node) {
+	for _, ch := range n.children {
+		if ch.field == nil {
+			r.env(ch)
+			continue
+		}
+		v := r.value(ch.field)
+		s := fmt.Sprintf("%v", v)
+		if list, ok := v.([]string); ok {
+			s = strings.Join(list, ",")
+		}
+		fmt.Fprintf(r.w, "export %s=%s%s\n", ch.field.env, shellQuote(s), r.comment(ch.field))
+	}
+}
+
+func (r *renderer) json(n *node) map[string]interface{} {
+	m := map[string]interface{}{}
+	for _, ch := range n.children {
+		switch {
+		case ch.field == nil:
+			m[ch.name] = r.json(ch)
+		case r.note != nil:
+			m[ch.name] = map[string]interface{}{"value": r.value(ch.field), "source": r.note(ch.field)}
+		default:
+			m[ch.name] = r.value(ch.field)
+		}
+	}
+	return m
+}
+
+// literal formats a value so that it is valid in both YAML and TOML.
+func literal(v interface{}) string {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String:
+		return strconv.Quote(rv.String())
+	case reflect.Float32, reflect.Float64:
+		s := strconv.FormatFloat(rv.Float(), 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		return s
+	case reflect.Slice:
+		items := make([]string, rv.Len())
+		for i := range items {
+			items[i] = literal(rv.Index(i).Interface())
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
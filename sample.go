@@ -0,0 +1,90 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+// WriteSample writes a sample config file (FormatYAML or FormatTOML) with every
+// field at its default value, commented with its description and markers for
+// required, enum and secret fields. Hidden fields and fields that can't be set
+// from a file are left out, so the sample always loads.
+func (c *Config) WriteSample(w io.Writer, format Format) error {
+	if format != FormatYAML && format != FormatTOML {
+		return fmt.Errorf("can't write a sample %s file, expected yaml or toml", format)
+	}
+	if c.fields == nil {
+		c.setup()
+	}
+	r := &renderer{w: w, value: c.sampleValue, above: sampleComments}
+	return r.render(c.tree(func(f *field) bool { return !f.hidden() && f.allows(SourceFile) }), format)
+}
+
+// AddInitCommand adds an `init [FILE]` subcommand that writes the sample
+// config to FILE (refusing to overwrite it), or to stdout.
+func (c *Config) AddInitCommand() {
+	var format string
+	initCmd := &cobra.Command{
+		Use:   "init [FILE]",
+		Short: "Write a sample config file",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			w := c.stdout()
+			if len(args) > 0 {
+				if ok, _ := afero.Exists(c.fs(), args[0]); ok {
+					return &os.PathError{Op: "open", Path: args[0], Err: os.ErrExist}
+				}
+				f, err := c.fs().OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+				if format == "" && filepath.Ext(args[0]) == ".toml" {
+					format = string(FormatTOML)
+				}
+			}
+			if format == "" {
+				format = string(FormatYAML)
+			}
+			return c.WriteSample(w, Format(format))
+		},
+	}
+	initCmd.Flags().StringVar(&format, "format", "", "yaml or toml (default from the file extension, else yaml)")
+	c.Cmd.AddCommand(initCmd)
+}
+
+// sampleValue never shows the default of a secret.
+func (c *Config) sampleValue(f *field) interface{} {
+	if f.secret() {
+		return reflect.Zero(f.value.Type()).Interface()
+	}
+	return c.defaultValue(f)
+}
+
+func sampleComments(f *field) []string {
+	var lines []string
+	if desc := f.desc(); desc != "" {
+		lines = append(lines, desc)
+	}
+	var markers []string
+	if f.required() {
+		markers = append(markers, "Required.")
+	}
+	if enum := f.enum(); len(enum) > 0 {
+		markers = append(markers, "One of: "+strings.Join(enum, ", ")+".")
+	}
+	if f.secret() {
+		markers = append(markers, "Secret, prefer $"+f.env+".")
+	}
+	if len(markers) > 0 {
+		lines = append(lines, strings.Join(markers, " "))
+	}
+	return lines
+}
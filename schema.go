@@ -0,0 +1,125 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+)
+
+const schemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema returns a JSON Schema (draft 2020-12) for the config file, with the
+// types, descriptions, defaults, required fields, enums and numeric bounds of
+// every field that can be set from a file. Publish it for editor completion,
+// or to validate config files in CI without building the binary.
+func (c *Config) Schema() ([]byte, error) {
+	if c.fields == nil {
+		c.setup()
+	}
+	s := c.schema(c.tree(func(f *field) bool { return f.allows(SourceFile) }))
+	s["$schema"] = schemaDraft
+	s["title"] = c.Cmd.Name()
+	if desc := c.Cmd.Long; desc != "" {
+		s["description"] = desc
+	}
+	return json.MarshalIndent(s, "", "  ")
+}
+
+func (c *Config) schema(n *node) map[string]interface{} {
+	props := map[string]interface{}{}
+	var required []string
+	for _, ch := range n.children {
+		if ch.field == nil {
+			props[ch.name] = c.schema(ch)
+			continue
+		}
+		props[ch.name] = c.fieldSchema(ch.field)
+		if ch.field.required() {
+			required = append(required, ch.name)
+		}
+	}
+	s := map[string]interface{}{
+		"type":       "object",
+		"properties": props,
+	}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+func (c *Config) fieldSchema(f *field) map[string]interface{} {
+	s := map[string]interface{}{}
+	switch f.value.Kind() {
+	case reflect.String:
+		s["type"] = "string"
+	case reflect.Bool:
+		s["type"] = "boolean"
+	case reflect.Int, reflect.Int64:
+		s["type"] = "integer"
+	case reflect.Float32, reflect.Float64:
+		s["type"] = "number"
+	case reflect.Slice:
+		s["type"] = "array"
+		s["items"] = map[string]interface{}{"type": "string"}
+	}
+	if desc := f.desc(); desc != "" {
+		s["description"] = desc
+	}
+	if _, ok := c.preset[f.Path()]; (ok || f.defaultTag() != "") && !f.secret() {
+		s["default"] = c.defaultValue(f)
+	}
+	if enum := f.enum(); len(enum) > 0 {
+		target := s
+		if f.value.Kind() == reflect.Slice {
+			target = s["items"].(map[string]interface{})
+		}
+		target["enum"] = enumValues(f.value.Type(), enum)
+	}
+	if min, ok := f.bound("min"); ok {
+		s["minimum"] = min
+	}
+	if max, ok := f.bound("max"); ok {
+		s["maximum"] = max
+	}
+	if f.secret() {
+		s["writeOnly"] = true
+	}
+	if _, ok := f.tag.Lookup("deprecated"); ok && len(f.aliases) == 0 {
+		s["deprecated"] = true
+	}
+	return s
+}
+
+// enumValues converts an `enum` tag to the JSON type of t, or of its items.
+// A value that doesn't parse is kept as a string.
+func enumValues(t reflect.Type, enum []string) []interface{} {
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	vals := make([]interface{}, len(enum))
+	for i, e := range enum {
+		vals[i] = e
+		switch t.Kind() {
+		case reflect.Bool:
+			if b, err := strconv.ParseBool(e); err == nil {
+				vals[i] = b
+			}
+		case reflect.Int, reflect.Int64:
+			if n, err := strconv.ParseInt(e, 10, 64); err == nil {
+				vals[i] = n
+			}
+		case reflect.Float32, reflect.Float64:
+			if n, err := strconv.ParseFloat(e, 64); err == nil {
+				vals[i] = n
+			}
+		}
+	}
+	return vals
+}
+
+// bound parses a `min` or `max` tag.
+func (f *field) bound(tag string) (float64, bool) {
+	b, err := strconv.ParseFloat(f.tag.Get(tag), 64)
+	return b, err == nil
+}
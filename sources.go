@@ -0,0 +1,103 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ErrSourceNotAllowed is reported for a value given through a source that
+// the field's `sources` or `noflag` tag rules out.
+var ErrSourceNotAllowed = errors.New("source not allowed")
+
+// sourceNames are the values of a `sources` tag.
+var sourceNames = map[string]Source{"flag": SourceFlag, "env": SourceEnv, "file": SourceFile}
+
+// allows reports whether f may be set through src. `noflag:"true"` is short
+// for `sources:"env,file"`.
+func (f *field) allows(src Source) bool {
+	if src == SourceFlag && f.tag.Get("noflag") == "true" {
+		return false
+	}
+	tag, ok := f.tag.Lookup("sources")
+	if !ok {
+		return true
+	}
+	for _, name := range strings.Split(tag, ",") {
+		if sourceNames[strings.TrimSpace(name)] == src {
+			return true
+		}
+	}
+	return false
+}
+
+// sources lists how f may be set, for messages.
+func (f *field) sources() string {
+	var names []string
+	if f.allows(SourceEnv) {
+		names = append(names, "$"+f.env)
+	}
+	if f.allows(SourceFile) {
+		names = append(names, "file key "+f.key)
+	}
+	if f.allows(SourceFlag) {
+		names = append(names, "--"+f.flag)
+	}
+	return strings.Join(names, " or ")
+}
+
+// checkSourcesTag reports a `sources` tag naming anything but flag, env and
+// file.
+func checkSourcesTag(sf reflect.StructField, path string) error {
+	tag, ok := sf.Tag.Lookup("sources")
+	if !ok {
+		return nil
+	}
+	for _, name := range strings.Split(tag, ",") {
+		if _, ok := sourceNames[strings.TrimSpace(name)]; !ok {
+			return fmt.Errorf("invalid sources tag %q @ %s", tag, path)
+		}
+	}
+	return nil
+}
+
+// flagless reports whether the flag named name belongs to a field that can't
+// be set by flag. Such flags are registered, hidden, only so env vars and
+// defaults can be layered onto them.
+func (c *Config) flagless(name string) bool {
+	for _, f := range c.fields {
+		if f.flag == name {
+			return !f.allows(SourceFlag)
+		}
+	}
+	return false
+}
+
+// checkSources reports values given on the command line or in the config
+// file for fields that don't accept them, and warns about secrets given on
+// the command line, where other users can see them in ps and shell history.
+// Env vars a field doesn't accept are simply not read.
+func (c *Config) checkSources() Errors {
+	var errs Errors
+	for _, f := range c.fields {
+		flag := c.flagChanged(f) && !c.fromEnv[f.flag]
+		if flag && !f.allows(SourceFlag) {
+			errs = append(errs, c.fieldError(f, fmt.Errorf("%w: set %s instead of --%s", ErrSourceNotAllowed, f.sources(), f.flag)))
+			continue
+		}
+		if c.source(f) == SourceFile && !f.allows(SourceFile) {
+			errs = append(errs, c.fieldError(f, fmt.Errorf("%w: set %s instead of file key %s", ErrSourceNotAllowed, f.sources(), f.key)))
+			continue
+		}
+		if flag && f.secret() {
+			c.log().Warn("secret given on the command line", "option", "--"+f.flag, "field", f.Path(), "message", "use $"+f.env+" or the config file")
+		}
+	}
+	for _, f := range c.positional {
+		if c.argSet[f.Path()] && f.secret() {
+			c.log().Warn("secret given on the command line", "option", f.argSyntax(), "field", f.Path(), "message", "use $"+f.env+" or the config file")
+		}
+	}
+	return errs
+}
@@ -0,0 +1,202 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+type strictMode int
+
+const (
+	strictOff strictMode = iota
+	strictWarn
+	strictError
+)
+
+// Strict makes Execute fail on config file keys and on env vars carrying the
+// command's prefix that match no field, instead of silently ignoring them.
+func (c *Config) Strict() {
+	c.strict = strictError
+}
+
+// StrictWarn is like Strict, but unknown keys are only logged as warnings,
+// see SetLogger.
+func (c *Config) StrictWarn() {
+	c.strict = strictWarn
+}
+
+// UnknownKeyError is a config file key or env var that matches no field.
+type UnknownKeyError struct {
+	Key        string // file key (log.levle) or env var (CONF_ADRR)
+	File       string // empty for env vars
+	Line       int    // 0 if the key could not be located
+	Suggestion string // closest known name, if any is close enough
+}
+
+func (e *UnknownKeyError) Error() string {
+	msg := "unknown env var " + e.Key
+	if e.File != "" {
+		msg = fmt.Sprintf("%s:%d: unknown key %s", e.File, e.Line, e.Key)
+	}
+	if e.Suggestion != "" {
+		msg += fmt.Sprintf(", did you mean %s?", e.Suggestion)
+	}
+	return msg
+}
+
+// checkUnknown reports file keys and prefixed env vars that map to no field.
+func (c *Config) checkUnknown(configFile string) error {
+	if c.strict == strictOff {
+		return nil
+	}
+	var errs []error
+	if c.file != nil {
+		data, _ := afero.ReadFile(c.fs(), configFile)
+		known := map[string]bool{}
+		for _, sub := range c.all() {
+			knownKeys(reflect.TypeOf(sub.cfg), strings.TrimSuffix(sub.keyPrefix(), "."), known)
+			for _, f := range sub.fields {
+				known[f.key] = true
+				for _, a := range f.aliases {
+					known[a.key] = true
+				}
+			}
+		}
+		keys := c.file.AllKeys()
+		sort.Strings(keys)
+		for _, key := range keys {
+			if !known[key] {
+				errs = append(errs, &UnknownKeyError{
+					Key:        key,
+					File:       configFile,
+					Line:       keyLine(data, key),
+					Suggestion: suggest(key, known),
+				})
+			}
+		}
+	}
+
+	if c.envPrefix() == "" {
+		return c.report(errs)
+	}
+	known := map[string]bool{}
+	for _, name := range builtinFlags {
+		known[c.envVar(strings.Replace(name, "-", "_", -1))] = true
+	}
+	for _, sub := range c.all() {
+		for _, f := range sub.fields {
+			known[f.env] = true
+			for _, a := range f.aliases {
+				known[a.env] = true
+			}
+		}
+	}
+	prefix := c.envVar("")
+	var envs []string
+	for _, name := range c.environ() {
+		if strings.HasPrefix(name, prefix) && !known[name] {
+			envs = append(envs, name)
+		}
+	}
+	sort.Strings(envs)
+	for _, name := range envs {
+		errs = append(errs, &UnknownKeyError{Key: name, Suggestion: suggest(name, known)})
+	}
+	return c.report(errs)
+}
+
+// report logs errs as warnings in StrictWarn mode, or joins them.
+func (c *Config) report(errs []error) error {
+	if c.strict == strictWarn {
+		for _, err := range errs {
+			c.log().Warn("unknown option", "error", err.Error())
+		}
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+// knownKeys collects every file key viper could unmarshal into t, including
+// fields hidden from flags with `flag:"false"`.
+func knownKeys(t reflect.Type, prefix string, known map[string]bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		key := strings.ToLower(keyName(sf))
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		known[key] = true
+		for _, alias := range aliases(sf) {
+			known[strings.TrimPrefix(prefix+"."+strings.ToLower(alias), ".")] = true
+		}
+		if sf.Type.Kind() == reflect.Struct {
+			knownKeys(sf.Type, key, known)
+		}
+	}
+}
+
+// keyLine finds the line of a dotted key in a YAML, TOML or JSON file by
+// looking for each path segment in turn. It is a best effort.
+func keyLine(data []byte, key string) int {
+	var segments []*regexp.Regexp
+	for _, name := range strings.Split(key, ".") {
+		segments = append(segments, regexp.MustCompile(`(?i)^\s*(\[\s*)?["']?`+regexp.QuoteMeta(name)+`["']?\s*([:=]|\])`))
+	}
+	line, seg := 0, 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() && seg < len(segments) {
+		line++
+		if segments[seg].MatchString(scanner.Text()) {
+			seg++
+		}
+	}
+	if seg < len(segments) {
+		return 0
+	}
+	return line
+}
+
+// suggest returns the known name closest to name, if it is plausibly a typo.
+func suggest(name string, known map[string]bool) string {
+	best, bestDist := "", len(name)/3+2
+	for k := range known {
+		if d := levenshtein(strings.ToLower(name), strings.ToLower(k)); d < bestDist || (d == bestDist && best != "" && k < best) {
+			best, bestDist = k, d
+		}
+	}
+	return best
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
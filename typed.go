@@ -0,0 +1,66 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+// Typed is a Config for a struct of type T. It avoids the type assertions and
+// reports an unusable T when it is created rather than while parsing.
+type Typed[T any] struct {
+	*Config
+	cfg *T
+}
+
+// NewTyped is New for a T it allocates itself.
+func NewTyped[T any](name, desc string, opts ...Option) (*Typed[T], error) {
+	return NewTypedWithCommand[T](&cobra.Command{
+		Use:  name,
+		Long: desc,
+		Run:  func(cmd *cobra.Command, args []string) {},
+	}, opts...)
+}
+
+// NewTypedWithCommand is NewWithCommand for a T it allocates itself.
+func NewTypedWithCommand[T any](cmd *cobra.Command, opts ...Option) (*Typed[T], error) {
+	cfg := new(T)
+	if err := checkShape(cfg); err != nil {
+		return nil, err
+	}
+	c := NewWithCommand(cmd, cfg, opts...)
+	if err := c.checkCollisions(); err != nil {
+		return nil, err
+	}
+	return &Typed[T]{Config: c, cfg: cfg}, nil
+}
+
+// Get returns the config struct. It is populated by Execute.
+func (t *Typed[T]) Get() *T {
+	return t.cfg
+}
+
+// Execute runs the command and returns the populated config struct.
+func (t *Typed[T]) Execute() (*T, error) {
+	_, err := t.Config.Execute()
+	return t.cfg, err
+}
+
+// Parse is an alias for Execute().
+func (t *Typed[T]) Parse() (*T, error) {
+	return t.Execute()
+}
+
+// Load reads a T from the command line, env vars and config file in one go,
+// for programs that don't otherwise use cobra. It is meant for main: the
+// command, and so the env var prefix and config file name, is named after
+// os.Args[0], and the args are os.Args[1:] unless WithArgs is given. Use
+// NewTyped and WithArgs, WithEnvPrefix and WithEnv to avoid process state.
+func Load[T any](opts ...Option) (*T, error) {
+	t, err := NewTyped[T](filepath.Base(os.Args[0]), "", opts...)
+	if err != nil {
+		return nil, err
+	}
+	return t.Execute()
+}
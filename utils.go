@@ -1,29 +1,29 @@
 package config
 
 import (
-	"fmt"
 	"reflect"
 	"strings"
+	"unicode"
 )
 
-func flagString(parent, field string) string {
+func flagString(parent, field string, acronyms ...string) string {
 	if len(parent) == 0 {
-		return strings.ToLower(hyphen(field))
+		return strings.ToLower(hyphen(field, acronyms...))
 	}
 	if len(field) == 0 {
-		return strings.ToLower(hyphen(parent))
+		return strings.ToLower(hyphen(parent, acronyms...))
 	}
-	return strings.ToLower(hyphen(parent) + "-" + hyphen(field))
+	return strings.ToLower(hyphen(parent, acronyms...) + "-" + hyphen(field, acronyms...))
 }
 
-func envString(parent, field string) string {
+func envString(parent, field string, acronyms ...string) string {
 	if len(parent) == 0 {
-		return strings.ToUpper(underscore(field))
+		return strings.ToUpper(underscore(field, acronyms...))
 	}
 	if len(field) == 0 {
-		return strings.ToUpper(underscore(parent))
+		return strings.ToUpper(underscore(parent, acronyms...))
 	}
-	return strings.ToUpper(underscore(parent) + "_" + underscore(field))
+	return strings.ToUpper(underscore(parent, acronyms...) + "_" + underscore(field, acronyms...))
 }
 
 func isUpper(c byte) bool {
@@ -43,17 +43,13 @@ func toLower(c byte) byte {
 }
 
 // Underscore converts "CamelCasedString" to "camel_cased_string".
-func underscore(s string) string {
-	return splitCamel(s, '_')
+func underscore(s string, acronyms ...string) string {
+	return splitCamel(s, '_', acronyms...)
 }
 
 // Underscore converts "CamelCasedString" to "camel-cased-string".
-func hyphen(s string) string {
-	return splitCamel(s, '-')
-}
-
-func sameCase(a, b byte) bool {
-	return (a >= 'a' && b >= 'a') || (a <= 'Z' && b <= 'Z')
+func hyphen(s string, acronyms ...string) string {
+	return splitCamel(s, '-', acronyms...)
 }
 
 /*
@@ -72,74 +68,97 @@ func sameCase(a, b byte) bool {
 "Sa":           "sa",
 "as":           "as",
 "lowerUpper":   "lower-upper",
-"DockerFoo": "docker-foo",
-"FooBar": "foo-bar",
-"FOOFooBAR": "foo-foo-bar",
-"FoOoFooBARBaZ": "fo-oo-foo-bar-ba-z",
-"FOOFOoBARBaZa": "foo-f-oo-bar-ba-za"
+"DockerFoo":    "docker-foo",
+"FooBar":       "foo-bar",
+"FOOFooBAR":    "foo-foo-bar",
+"HTTP2Port":    "http2-port",
+"S3Bucket":     "s3-bucket",
+"OAuthToken":   "o-auth-token", or "oauth-token" with the acronym OAuth
+"ÜberName":     "über-name",
+"Max_Size":     "max-size",
 */
 
-func splitCamel(s string, sep byte) string {
-	var splStr []byte
-	sLen := len(s)
-	// easier to handle short strings here:
-	if sLen < 2 {
-		return strings.ToLower(s)
-	} else if sLen == 2 {
-		if isLower(s[0]) && isUpper(s[1]) {
-			return fmt.Sprintf("%c-%c", s[0], toLower(s[1]))
+// splitCamel splits s into words, lower cases them and joins them with sep.
+// A word starts at an upper case letter after a lower case letter or a
+// digit, and at the last capital of an acronym followed by a lower case word
+// (URLStuff). Digits belong to the word before them (HTTP2Port), a single
+// lower case letter ending s stays with an acronym (URLs), underscores only
+// separate and the given acronyms are never split (OAuth, OAuth2).
+func splitCamel(s string, sep byte, acronyms ...string) string {
+	return strings.ToLower(strings.Join(camelWords([]rune(s), acronyms), string(sep)))
+}
+
+func camelWords(rs []rune, acronyms []string) []string {
+	var words []string
+	start := 0
+	flush := func(end int) {
+		if end > start {
+			words = append(words, string(rs[start:end]))
 		}
-		return strings.ToLower(s)
+		start = end
 	}
-	var appendLastCharacter []byte
-	// the final letter doesn't follow the same rules (to support plurals like URLs)
-	// TestURLs --> test-urls (Ending Multiword Caps + one lowercase)
-	// Also catches TestT --> test-t
-	if (isLower(s[sLen-1]) && isUpper(s[sLen-2]) && isUpper(s[sLen-3])) || (isLower(s[sLen-2]) && isUpper(s[sLen-1])) {
-		if isUpper(s[sLen-1]) {
-			appendLastCharacter = append(appendLastCharacter, sep)
+	for i := 0; i < len(rs); {
+		if rs[i] == '_' {
+			flush(i)
+			i++
+			start = i
+			continue
 		}
-		appendLastCharacter = append(appendLastCharacter, s[sLen-1])
-		s = s[0 : sLen-1]
-		sLen--
-	}
-	inWord := false
-	wordStart := 0
-	for i := 0; i < sLen-1; i++ {
-		l, m := s[i], s[i+1]
-		if !inWord && isLower(l) && isUpper(m) { // aU -> a-u...
-			wordStart = i - 1
-			if wordStart < 0 {
-				wordStart = 0
+		if i == start || wordStart(rs, i) {
+			flush(i)
+			if end := acronymAt(rs, i, acronyms); end > i {
+				flush(end)
+				i = end
+				continue
 			}
-			// do not continue (keep going).
-		} else if !inWord {
-			inWord = true
-			wordStart = i
-			continue
 		}
-		// now in word
-		if sameCase(l, m) {
+		i++
+	}
+	flush(len(rs))
+	return words
+}
+
+// isCapital reports whether r is an upper case letter with a lower case
+// form. Capitals without one (ϓ) are treated like caseless letters, so a name
+// splits the same way once lower cased.
+func isCapital(r rune) bool {
+	return unicode.IsUpper(r) && unicode.ToLower(r) != r
+}
+
+// wordStart reports whether a new word starts at rs[i].
+func wordStart(rs []rune, i int) bool {
+	if i == 0 || !isCapital(rs[i]) {
+		return false
+	}
+	if !isCapital(rs[i-1]) {
+		return true // aB, 2B
+	}
+	// ABc ends the acronym A, unless c is the last letter (URLs).
+	return i+2 < len(rs) && unicode.IsLower(rs[i+1])
+}
+
+// acronymAt is the end of the longest acronym (or its plural), with any
+// digits after it, starting at rs[i] and followed by the start of another
+// word, else i.
+func acronymAt(rs []rune, i int, acronyms []string) int {
+	best := i
+	for _, word := range acronyms {
+		w := []rune(word)
+		end := i + len(w)
+		if end <= best || end > len(rs) || string(rs[i:end]) != word {
 			continue
 		}
-		// URLStuff -> url-stuff -- use l as boundry instead of m (case of multi caps)
-		end := i + 1
-		if i-1 >= 0 && isLower(m) && isUpper(l) && isUpper(s[i-1]) {
-			end--
+		if end < len(rs) && rs[end] == 's' && (end+1 == len(rs) || !unicode.IsLower(rs[end+1])) {
+			end++
+		}
+		for end < len(rs) && unicode.IsDigit(rs[end]) {
+			end++
+		}
+		if end == len(rs) || rs[end] == '_' || wordStart(rs, end) {
+			best = end
 		}
-		//FOOFoo -> foo-foo
-		splStr = append(splStr, s[wordStart:end]...)
-		splStr = append(splStr, sep)
-		wordStart = end
-		inWord = (end == i) // if we moved back above, then we're still in a word.
-	}
-	if inWord {
-		splStr = append(splStr, s[wordStart:]...)
-	}
-	if len(appendLastCharacter) > 0 {
-		splStr = append(splStr, appendLastCharacter...)
 	}
-	return strings.ToLower(string(splStr))
+	return best
 }
 
 // this is included for historical reference. Might be more compact...
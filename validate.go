@@ -0,0 +1,63 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// Defaulter is implemented by config structs whose defaults can't be written
+// as a `default` tag (CPU count, hostname, ...). SetDefaults is called on the
+// root struct and on every nested struct before the file, env and flags are
+// layered on top.
+type Defaulter interface {
+	SetDefaults()
+}
+
+// Validator is implemented by config structs with rules that can't be written
+// as tags, such as "TLS cert and key must both be set". Validate is called on
+// the root struct and on every nested struct once all sources are merged.
+type Validator interface {
+	Validate() error
+}
+
+// StructError is an error returned by a Validator, annotated with the path of
+// the struct that returned it (empty for the root struct).
+type StructError struct {
+	Path string
+	Err  error
+}
+
+func (e *StructError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *StructError) Unwrap() error {
+	return e.Err
+}
+
+func (c *Config) setDefaults() {
+	eachStruct(c.cfg, func(v reflect.Value, crumbs []string) error {
+		if d, ok := v.Interface().(Defaulter); ok {
+			d.SetDefaults()
+		}
+		return nil
+	})
+}
+
+// validate runs every Validator and joins all of their errors.
+func (c *Config) validate() error {
+	var errs []error
+	eachStruct(c.cfg, func(v reflect.Value, crumbs []string) error {
+		if vd, ok := v.Interface().(Validator); ok {
+			if err := vd.Validate(); err != nil {
+				errs = append(errs, &StructError{Path: strings.Join(crumbs, "."), Err: err})
+			}
+		}
+		return nil
+	})
+	return errors.Join(errs...)
+}